@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+
+	"music/engine"
+)
+
+// How the library is grouped at the top level of the browser
+type browseMode int
+
+const (
+	browseArtists browseMode = iota
+	browseGenres
+)
+
+// A row of the browser: an artist, genre or album to open, or a track
+type browseRow struct {
+	label  string
+	name   string         // the group's name, to open it by
+	tracks []engine.Track // what playing the row plays
+	start  int            // for a track, its place in tracks
+	leaf   bool
+}
+
+// What queueing the row adds: a group's tracks, or just the one track
+func (row browseRow) queued() []engine.Track {
+	if row.leaf {
+		return row.tracks[row.start : row.start+1]
+	}
+	return row.tracks
+}
+
+// The library browser shows artists (or genres), then their albums, then the
+// albums' tracks, in place of the track list
+type browser struct {
+	mode     browseMode
+	path     []string // the artist or genre opened, then the album
+	selected int
+	scroll   int
+
+	rows    []browseRow
+	version int // library version the rows were built from, -1 to rebuild
+}
+
+func (b *browser) group(t engine.Track) string {
+	name := t.Artist
+	if b.mode == browseGenres {
+		name = t.Genre
+	}
+	if name == "" {
+		return "Unknown"
+	}
+	return name
+}
+
+func albumName(t engine.Track) string {
+	if t.Album == "" {
+		return "Unknown album"
+	}
+	return t.Album
+}
+
+// Where the browser is, e.g. "Artists > Queen"
+func (b *browser) title() string {
+	top := "Artists"
+	if b.mode == browseGenres {
+		top = "Genres"
+	}
+	return strings.Join(append([]string{top}, b.path...), " > ")
+}
+
+// Rows for the current level from all the library's tracks, which are in album order
+func (b *browser) build(all []engine.Track) {
+	var tracks []engine.Track
+	for _, t := range all {
+		if len(b.path) > 0 && b.group(t) != b.path[0] || len(b.path) > 1 && albumName(t) != b.path[1] {
+			continue
+		}
+		tracks = append(tracks, t)
+	}
+
+	switch len(b.path) {
+	case 0:
+		b.rows = groupRows(tracks, b.group)
+	case 1:
+		b.rows = groupRows(tracks, albumName)
+	default:
+		b.rows = b.rows[:0]
+		for i, t := range tracks {
+			b.rows = append(b.rows, browseRow{label: t.DisplayName(), tracks: tracks, start: i, leaf: true})
+		}
+	}
+}
+
+// One row per distinct name, alphabetically, each keeping its tracks' order
+func groupRows(tracks []engine.Track, name func(engine.Track) string) []browseRow {
+	groups := make(map[string][]engine.Track)
+	var names []string
+	for _, t := range tracks {
+		n := name(t)
+		if _, ok := groups[n]; !ok {
+			names = append(names, n)
+		}
+		groups[n] = append(groups[n], t)
+	}
+	sort.Slice(names, func(i, j int) bool { return strings.ToLower(names[i]) < strings.ToLower(names[j]) })
+
+	rows := make([]browseRow, 0, len(names))
+	for _, n := range names {
+		rows = append(rows, browseRow{
+			label:  fmt.Sprintf("%s (%d)", n, len(groups[n])),
+			name:   n,
+			tracks: groups[n],
+		})
+	}
+	return rows
+}
+
+// Go into a level, or back out of one, starting at its top
+func (b *browser) setPath(path []string) {
+	b.path = path
+	b.selected, b.scroll = 0, 0
+	b.version = -1
+}
+
+func (p *Player) toggleBrowser() {
+	p.browsing = !p.browsing
+	p.browser.version = -1
+}
+
+// The browser's rows, rebuilt when the library has changed
+func (p *Player) browserRows() []browseRow {
+	if p.browser.version != p.library.currentVersion() {
+		all, version := p.library.all()
+		p.browser.build(all)
+		p.browser.version = version
+		if p.browser.selected >= len(p.browser.rows) {
+			p.browser.selected = 0
+		}
+	}
+	return p.browser.rows
+}
+
+// Open a group, or play a track along with the rest of its album
+func (p *Player) openRow(row browseRow) error {
+	if row.leaf {
+		return p.playRow(row)
+	}
+	p.browser.setPath(append(p.browser.path, row.name))
+	return nil
+}
+
+// Play-artist, play-genre and play-album: the row's tracks become the list
+func (p *Player) playRow(row browseRow) error {
+	if err := p.engine.LoadAt(row.tracks, row.start); err != nil {
+		return err
+	}
+	p.playlistPath = ""
+	p.selection = p.browser.title()
+	if !row.leaf {
+		p.selection += " > " + row.name
+	}
+	p.setStatus("Playing " + p.selection)
+	return nil
+}
+
+// Arrow keys and the mouse pick a row, Enter or a click opens it, P plays it,
+// Q or a right-click queues it, Backspace goes back up and Tab switches
+// between artists and genres
+func (p *Player) updateBrowser() error {
+	b := &p.browser
+	rows := p.browserRows()
+	visible := p.visibleRows()
+
+	back := func() {
+		if len(b.path) > 0 {
+			b.setPath(b.path[:len(b.path)-1])
+		}
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		if len(b.path) == 0 {
+			p.browsing = false
+			return nil
+		}
+		back()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyB) {
+		p.browsing = false
+		return nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBackspace) {
+		back()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		b.mode = (b.mode + 1) % 2
+		b.setPath(nil)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+		p.engine.TogglePlay()
+	}
+
+	if repeatingKeyPressed(ebiten.KeyDown) {
+		b.selected++
+	}
+	if repeatingKeyPressed(ebiten.KeyUp) {
+		b.selected--
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageDown) {
+		b.selected += visible
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageUp) {
+		b.selected -= visible
+	}
+	if b.selected >= len(rows) {
+		b.selected = len(rows) - 1
+	}
+	if b.selected < 0 {
+		b.selected = 0
+	}
+	if b.selected < b.scroll {
+		b.scroll = b.selected
+	}
+	if b.selected >= b.scroll+visible {
+		b.scroll = b.selected - visible + 1
+	}
+
+	mouseX, mouseY := ebiten.CursorPosition()
+	mouse := image.Pt(mouseX, mouseY)
+	if _, wheelY := ebiten.Wheel(); mouse.In(p.ui.trackList) && wheelY != 0 {
+		if wheelY > 0 {
+			b.scroll -= 3
+		} else {
+			b.scroll += 3
+		}
+		if b.scroll > len(rows)-visible {
+			b.scroll = len(rows) - visible
+		}
+		if b.scroll < 0 {
+			b.scroll = 0
+		}
+	}
+
+	if b.selected < len(rows) {
+		if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter) {
+			return p.openRow(rows[b.selected])
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyP) {
+			return p.playRow(rows[b.selected])
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyQ) {
+			return p.queueTracks(rows[b.selected].queued(), rows[b.selected].label)
+		}
+	}
+
+	// Clicking the title row goes back up a level
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && mouse.In(p.ui.trackList) {
+		list := p.trackRows()
+		if !mouse.In(list) {
+			back()
+			return nil
+		}
+		row := b.scroll + (mouseY-list.Min.Y)/rowHeight()
+		if row < len(rows) {
+			b.selected = row
+			return p.openRow(rows[row])
+		}
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) && mouse.In(p.trackRows()) {
+		row := b.scroll + (mouseY-p.trackRows().Min.Y)/rowHeight()
+		if row < len(rows) {
+			b.selected = row
+			return p.queueTracks(rows[row].queued(), rows[row].label)
+		}
+	}
+	return p.clickButtons()
+}
+
+func (p *Player) drawBrowser(screen *ebiten.Image) {
+	b := &p.browser
+	face := myFont
+	rh := rowHeight()
+	descent := face.Metrics().Descent.Ceil()
+
+	// Title row, in the filter box's place
+	list := p.ui.trackList
+	box := image.Rect(list.Min.X, list.Min.Y, list.Max.X, list.Min.Y+rh)
+	draw.Draw(screen, box, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+	text.Draw(screen, b.title()+"   (Tab Artists/Genres, P Play, Q Queue, Bksp Back)", face, box.Min.X+5, box.Max.Y-descent-2, theme.Text)
+
+	rows := p.browserRows()
+	area := p.trackRows()
+	if len(rows) == 0 {
+		text.Draw(screen, "The library is empty; open a folder to scan it", face, area.Min.X+10, area.Min.Y+rh-descent-2, theme.Text)
+		return
+	}
+
+	visible := p.visibleRows()
+	for row := 0; row < visible && b.scroll+row < len(rows); row++ {
+		top := area.Min.Y + row*rh
+		var color color.Color = theme.Text
+		if b.scroll+row == b.selected {
+			selection := image.Rect(area.Min.X, top, area.Max.X-scrollBarWidth-2, top+rh)
+			draw.Draw(screen, selection, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+			color = theme.Highlight
+		}
+		text.Draw(screen, rows[b.scroll+row].label, face, area.Min.X+10, top+rh-descent-2, color)
+	}
+
+	if len(rows) > visible {
+		bar := image.Rect(area.Max.X-scrollBarWidth, area.Min.Y, area.Max.X, area.Max.Y)
+		draw.Draw(screen, bar, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+		thumbTop := bar.Min.Y + bar.Dy()*b.scroll/len(rows)
+		thumbBottom := bar.Min.Y + bar.Dy()*(b.scroll+visible)/len(rows)
+		thumb := image.Rect(bar.Min.X, thumbTop, bar.Max.X, thumbBottom)
+		draw.Draw(screen, thumb, &image.Uniform{C: theme.Progress}, image.Point{}, draw.Src)
+	}
+}
@@ -36,7 +36,7 @@ cp -r mp3/* build/mp3/ 2>/dev/null || echo "No MP3s found - that's okay if you'l
 
 # Build the executable
 echo "Building $TARGET_OS executable..."
-go build -o build/$EXE_NAME main.go
+go build -o build/$EXE_NAME .
 
 echo "Build complete!"
 echo "You can find the executable and resources in the 'build' directory"
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+	"github.com/hajimehoshi/ebiten/v2/audio/mp3"
+	"github.com/hajimehoshi/ebiten/v2/audio/vorbis"
+	"github.com/hajimehoshi/ebiten/v2/audio/wav"
+
+	"music/engine"
+)
+
+// audioStream is decoded audio as 32-bit float stereo (8 bytes per frame) at the
+// file's own sample rate, which is what every decoder below produces
+type audioStream interface {
+	io.ReadSeeker
+	Length() int64
+	SampleRate() int
+}
+
+// A decoder for one audio format, recognised by file extension or by the magic
+// bytes at the start of the stream, after any ID3v2 tag
+type decoder struct {
+	name       string
+	extensions []string
+	magic      func(header []byte) bool
+	decode     func(src io.ReadSeeker) (audioStream, error)
+}
+
+// Formats the player can play
+var decoders = []decoder{
+	{
+		name:       "MP3",
+		extensions: []string{".mp3"},
+		magic: func(header []byte) bool {
+			return len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0
+		},
+		decode: func(src io.ReadSeeker) (audioStream, error) {
+			stream, err := mp3.DecodeF32(src)
+			if err != nil {
+				return nil, err
+			}
+			return stream, nil
+		},
+	},
+	{
+		name:       "WAV",
+		extensions: []string{".wav", ".wave"},
+		magic: func(header []byte) bool {
+			return len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WAVE"
+		},
+		decode: func(src io.ReadSeeker) (audioStream, error) {
+			stream, err := wav.DecodeF32(src)
+			if err != nil {
+				return nil, err
+			}
+			return stream, nil
+		},
+	},
+	{
+		name:       "OGG Vorbis",
+		extensions: []string{".ogg", ".oga"},
+		magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("OggS"))
+		},
+		decode: func(src io.ReadSeeker) (audioStream, error) {
+			stream, err := vorbis.DecodeF32(src)
+			if err != nil {
+				return nil, err
+			}
+			return stream, nil
+		},
+	},
+	{
+		name:       "FLAC",
+		extensions: []string{".flac"},
+		magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("fLaC"))
+		},
+		decode: func(src io.ReadSeeker) (audioStream, error) {
+			stream, err := decodeFLAC(src)
+			if err != nil {
+				return nil, err
+			}
+			return stream, nil
+		},
+	},
+}
+
+// Decoder for a file name's extension, or nil if the format isn't supported
+func decoderFor(name string) *decoder {
+	ext := strings.ToLower(filepath.Ext(name))
+	for i := range decoders {
+		for _, e := range decoders[i].extensions {
+			if e == ext {
+				return &decoders[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Whether a file looks like something the player can play
+func isAudioFile(name string) bool {
+	return decoderFor(name) != nil
+}
+
+// Open a decoded stream for a file. The extension is trusted when the file's
+// magic bytes agree with it; otherwise the magic bytes pick the decoder, so a
+// mislabelled file still plays.
+func openStream(src io.ReadSeeker, name string) (audioStream, error) {
+	header, tagged, err := streamHeader(src)
+	if err != nil {
+		return nil, err
+	}
+
+	chosen := decoderFor(name)
+	if chosen == nil || !chosen.magic(header) {
+		for i := range decoders {
+			if decoders[i].magic(header) {
+				chosen = &decoders[i]
+				break
+			}
+		}
+	}
+	if chosen == nil && tagged {
+		chosen = decoderFor(".mp3") // an MP3 stream needn't start right after its tag
+	}
+	if chosen == nil {
+		return nil, fmt.Errorf("%s: unsupported audio format", filepath.Base(name))
+	}
+
+	stream, err := chosen.decode(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", filepath.Base(name), chosen.name, err)
+	}
+	return stream, nil
+}
+
+// The first bytes of the audio stream, past an ID3v2 tag if there is one, as
+// MP3 and FLAC files can both start with one. The source is left at the start.
+func streamHeader(src io.ReadSeeker) (header []byte, tagged bool, err error) {
+	read := func() ([]byte, error) {
+		header := make([]byte, 12)
+		n, err := io.ReadFull(src, header)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, err
+		}
+		return header[:n], nil
+	}
+	if header, err = read(); err != nil {
+		return nil, false, err
+	}
+	if len(header) >= 10 && string(header[:3]) == "ID3" {
+		tagged = true
+		size := 10 + int64(synchsafe(header[6:10]))
+		if header[5]&0x10 != 0 {
+			size += 10 // footer
+		}
+		if _, err := src.Seek(size, io.SeekStart); err != nil {
+			return nil, false, err
+		}
+		if header, err = read(); err != nil {
+			return nil, false, err
+		}
+	}
+	_, err = src.Seek(0, io.SeekStart)
+	return header, tagged, err
+}
+
+// A decoded file for the engine, resampled to the player's rate
+type trackSource struct {
+	io.ReadSeeker
+	file   *os.File
+	length int64
+}
+
+func (s *trackSource) Length() int64 { return s.length }
+func (s *trackSource) Close() error  { return s.file.Close() }
+
+// openTrack is the engine's Opener
+func openTrack(path string) (engine.Source, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := openStream(file, path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	source := &trackSource{ReadSeeker: stream, file: file, length: stream.Length()}
+	if rate := stream.SampleRate(); rate != sampleRate {
+		source.ReadSeeker = audio.ResampleF32(stream, stream.Length(), rate, sampleRate)
+		// Same rounding as the resampler so the length matches what it produces
+		length := int64(float64(stream.Length()) * float64(sampleRate) / float64(rate))
+		source.length = length / engine.BytesPerFrame * engine.BytesPerFrame
+	}
+	return source, nil
+}
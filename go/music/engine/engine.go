@@ -0,0 +1,428 @@
+// Package engine is the music player's playback core: the track list, play
+// order, volume and position. It has no window or audio device of its own, so
+// it can be driven headless; the caller supplies an Opener to decode files and
+// a Sink that pulls samples from the engine.
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// BytesPerFrame is the size of one stereo frame of 32-bit float samples, the
+// only format the engine deals in
+const BytesPerFrame = 8
+
+// Source is a decoded track: 32-bit float stereo at the engine's sample rate
+type Source interface {
+	io.ReadSeeker
+	io.Closer
+	Length() int64 // in bytes
+}
+
+// Opener decodes the file at path
+type Opener func(path string) (Source, error)
+
+// Sink is the audio output. It reads from the engine while playing, e.g. an
+// ebiten audio.Player created with the engine as its stream.
+type Sink interface {
+	Play()
+	Pause()
+	IsPlaying() bool
+}
+
+// Engine plays a list of tracks. It is safe to use from several goroutines;
+// the sink reads from it on its own.
+type Engine struct {
+	mu          sync.Mutex
+	open        Opener
+	sink        Sink
+	sampleRate  int
+	events      []Event
+	subscribers []func(Event)
+
+	tracks  []Track
+	current int
+	source  Source // decoded current track, nil when there is nothing to play
+	pos     int64  // bytes read from source
+	playing bool
+	volume  float64
+
+	generation int // counts the sources closed, so an open that took too long can tell it's stale
+	opening    int // generation of the current track being opened, 0 when none is
+
+	shuffle      bool
+	repeat       RepeatMode
+	shuffleQueue []int // tracks still to play in this shuffled pass
+	history      []int // tracks played before the current one while shuffling
+
+	upNext []Track // the play queue, ahead of the list's order
+	queued *Track  // the queued track playing, nil when it's one from the list
+
+	next      *upcoming // track to play after the current one, once picked
+	crossfade time.Duration
+	mix       []byte // the next track's samples while crossfading
+
+	tap    [tapSize]float32 // latest samples played, for Recent
+	tapPos int
+
+	eq        [10]biquad
+	eqGains   EQGains
+	eqPreGain float64 // headroom for the biggest boost
+	gainMode  GainMode
+	measured  map[string]ReplayGain // gains worked out for tracks without tags, by path
+	measuring bool
+	closed    bool
+}
+
+// New creates an engine producing audio at sampleRate
+func New(open Opener, sampleRate int) *Engine {
+	return &Engine{open: open, sampleRate: sampleRate, volume: 1}
+}
+
+// SetSink connects the audio output. The engine is the sink's stream, so the
+// sink is usually created after the engine and connected here.
+func (e *Engine) SetSink(sink Sink) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sink = sink
+}
+
+// Update opens the next track ahead of time, keeps the sink running only while
+// there is something to play and delivers queued events. Call it regularly,
+// e.g. once per frame.
+func (e *Engine) Update() {
+	e.prepareNext()
+
+	e.mu.Lock()
+	if e.sink != nil {
+		if e.playing && !e.sink.IsPlaying() {
+			e.sink.Play()
+		} else if !e.playing && e.sink.IsPlaying() {
+			e.sink.Pause()
+		}
+	}
+	events := e.events
+	e.events = nil
+	subscribers := e.subscribers
+	e.mu.Unlock()
+
+	for _, event := range events {
+		for _, f := range subscribers {
+			f(event)
+		}
+	}
+}
+
+// Read fills p with the next samples, moving on to the next track as each one
+// ends so there's no gap between them, or fading one into the other when a
+// crossfade is set. Silence is produced while paused or when there is nothing
+// to play; the stream itself never ends.
+func (e *Engine) Read(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	n := 0
+	empty := 0 // tracks in a row that had nothing to read, so a list of them can't spin
+	for e.playing && e.source != nil && len(p)-n >= BytesPerFrame {
+		chunk := p[n : n+(len(p)-n)/BytesPerFrame*BytesPerFrame]
+		remaining := e.source.Length() - e.pos
+		fade := e.durationToBytes(e.crossfade)
+		fading := e.shouldFade(remaining, fade)
+		// Stop short of the crossfade so it is mixed from its first frame
+		if limit := (remaining - fade) / BytesPerFrame * BytesPerFrame; fade > 0 && limit > 0 && limit < int64(len(chunk)) {
+			chunk = chunk[:limit]
+		}
+		read, err := io.ReadFull(e.source, chunk)
+		read -= read % BytesPerFrame
+		samples := p[n : n+read]
+		scale(samples, e.trackGain(*e.playingTrack()))
+		if fading {
+			e.mixNext(samples, remaining, fade)
+		}
+		e.equalize(samples)
+		e.record(samples)
+		scale(samples, float32(e.volume))
+		e.pos += int64(read)
+		n += read
+		if read > 0 {
+			empty = 0
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if empty++; empty > len(e.tracks)+len(e.upNext) {
+				e.playing = false
+				e.emit(StateChanged)
+				break
+			}
+			e.trackEnded()
+		} else if err != nil {
+			e.emitError(err)
+			e.playing = false
+			e.emit(StateChanged)
+		}
+	}
+	for i := n; i < len(p); i++ {
+		p[i] = 0
+	}
+	e.recordSilence((len(p) - n) / BytesPerFrame)
+	return len(p), nil
+}
+
+// Multiply samples by gain
+func scale(samples []byte, gain float32) {
+	if gain == 1 {
+		return
+	}
+	for i := 0; i+4 <= len(samples); i += 4 {
+		v := math.Float32frombits(binary.LittleEndian.Uint32(samples[i:]))
+		binary.LittleEndian.PutUint32(samples[i:], math.Float32bits(v*gain))
+	}
+}
+
+// Load replaces the track list and starts playing the first track
+func (e *Engine) Load(tracks []Track) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.load(tracks, 0)
+}
+
+// LoadAt replaces the track list and starts playing the track at start, which
+// is opened straight away rather than after the first one
+func (e *Engine) LoadAt(tracks []Track, start int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.load(tracks, start)
+}
+
+// Replace swaps in a new version of the track list, e.g. after a rescan. The
+// current track carries on if it's still in the list, or if it came from the
+// queue; otherwise it's like Load.
+func (e *Engine) Replace(tracks []Track) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.source == nil && e.opening == 0 {
+		return e.load(tracks, 0)
+	}
+	at := -1
+	if e.current < len(e.tracks) {
+		for i, track := range tracks {
+			if track.Path == e.tracks[e.current].Path {
+				at = i
+				break
+			}
+		}
+	}
+	if at < 0 && e.queued == nil {
+		return e.load(tracks, 0)
+	}
+	e.dropNext()
+	old := e.tracks
+	e.tracks = append([]Track(nil), tracks...)
+	if at >= 0 {
+		e.tracks[at].Duration = old[e.current].Duration
+		e.current = at
+	} else {
+		e.current = 0 // the list starts again from the top after the queue
+	}
+	e.resetOrder()
+	e.emit(ListChanged)
+	e.startMeasuring()
+	return nil
+}
+
+func (e *Engine) load(tracks []Track, start int) error {
+	e.dropNext()
+	e.closeSource()
+	e.tracks = append([]Track(nil), tracks...)
+	e.current = 0
+	if start > 0 && start < len(e.tracks) {
+		e.current = start
+	}
+	e.queued = nil
+	e.resetOrder()
+	e.emit(ListChanged)
+	e.startMeasuring()
+	if len(e.tracks) == 0 {
+		e.playing = false
+		e.emit(TrackChanged)
+		return nil
+	}
+	return e.openCurrent(true)
+}
+
+// Tracks returns a copy of the track list
+func (e *Engine) Tracks() []Track {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Track(nil), e.tracks...)
+}
+
+// Current is the index of the current track in the list. While a queued track
+// plays it is the one the list carries on after.
+func (e *Engine) Current() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.current
+}
+
+// Open the current track, or the queued one playing, and start it playing or
+// leave it paused
+// Open the track to play and start it if play says to. The lock is held but let
+// go while the decoder opens, so a slow file doesn't hold up the audio or
+// anyone asking about it; the track is dropped if another has been picked by then.
+func (e *Engine) openCurrent(play bool) error {
+	e.closeSource()
+	generation := e.generation
+	path := e.playingTrack().Path
+	e.opening = generation
+	e.mu.Unlock()
+
+	source, err := e.open(path)
+
+	e.mu.Lock()
+	if e.opening == generation {
+		e.opening = 0
+	}
+	if e.generation != generation {
+		if source != nil {
+			source.Close()
+		}
+		return nil
+	}
+	if err != nil {
+		e.playing = false
+		e.emit(StateChanged)
+		return err
+	}
+	e.source = source
+	e.playingTrack().Duration = e.bytesToDuration(source.Length())
+	if e.playing != play {
+		e.playing = play
+		e.emit(StateChanged)
+	}
+	e.emit(TrackChanged)
+	return nil
+}
+
+func (e *Engine) closeSource() {
+	if e.source != nil {
+		e.source.Close()
+		e.source = nil
+	}
+	e.pos = 0
+	e.generation++
+}
+
+func (e *Engine) bytesToDuration(n int64) time.Duration {
+	return time.Duration(n/BytesPerFrame) * time.Second / time.Duration(e.sampleRate)
+}
+
+// Play resumes the current track
+func (e *Engine) Play() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.source != nil && !e.playing {
+		e.playing = true
+		e.emit(StateChanged)
+	}
+}
+
+// Pause stops playback where it is
+func (e *Engine) Pause() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.playing {
+		e.playing = false
+		e.emit(StateChanged)
+	}
+}
+
+// TogglePlay pauses when playing and plays when paused
+func (e *Engine) TogglePlay() {
+	if e.IsPlaying() {
+		e.Pause()
+	} else {
+		e.Play()
+	}
+}
+
+func (e *Engine) IsPlaying() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.playing
+}
+
+// Position is how far into the current track playback has got
+func (e *Engine) Position() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.bytesToDuration(e.pos)
+}
+
+// Duration is the length of the current track, 0 if nothing is loaded
+func (e *Engine) Duration() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.source == nil {
+		return 0
+	}
+	return e.playingTrack().Duration
+}
+
+// Seek jumps to a position in the current track, clamped to the track
+func (e *Engine) Seek(position time.Duration) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.source == nil {
+		return errors.New("engine: nothing to seek in")
+	}
+	if position < 0 {
+		position = 0
+	}
+	// A crossfade under way no longer lines up with the position
+	if e.next != nil && e.next.pos > 0 {
+		e.dropNext()
+	}
+	offset := int64(position.Seconds()*float64(e.sampleRate)) * BytesPerFrame
+	if offset > e.source.Length() {
+		offset = e.source.Length()
+	}
+	if _, err := e.source.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	e.pos = offset
+	e.emit(Seeked)
+	return nil
+}
+
+// Volume is the playback gain from 0 to 1
+func (e *Engine) Volume() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.volume
+}
+
+func (e *Engine) SetVolume(volume float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	volume = math.Max(0, math.Min(volume, 1))
+	if volume != e.volume {
+		e.volume = volume
+		e.emit(VolumeChanged)
+	}
+}
+
+// Close releases the current track and any lined up after it
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dropNext()
+	e.closeSource()
+	e.playing = false
+	e.closed = true
+	return nil
+}
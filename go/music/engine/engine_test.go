@@ -0,0 +1,616 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// A low rate keeps the fake tracks short: a second is 100 frames
+const testRate = 100
+
+// fakeSource is a decoded track whose every frame says where it came from:
+// the left channel is the track's id and the right is the frame's number
+type fakeSource struct {
+	id     float32
+	frames int64
+	pos    int64 // bytes
+	closed bool
+	frame  func(i int64) (left, right float32) // overrides the id and number
+}
+
+func (s *fakeSource) Read(p []byte) (int, error) {
+	n := 0
+	for ; n+BytesPerFrame <= len(p) && s.pos < s.frames*BytesPerFrame; n += BytesPerFrame {
+		i := s.pos / BytesPerFrame
+		left, right := s.id, float32(i)
+		if s.frame != nil {
+			left, right = s.frame(i)
+		}
+		binary.LittleEndian.PutUint32(p[n:], math.Float32bits(left))
+		binary.LittleEndian.PutUint32(p[n+4:], math.Float32bits(right))
+		s.pos += BytesPerFrame
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (s *fakeSource) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart || offset < 0 || offset > s.frames*BytesPerFrame {
+		return 0, errors.New("fake: bad seek")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *fakeSource) Length() int64 { return s.frames * BytesPerFrame }
+
+func (s *fakeSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+// fakeLibrary opens tracks named by their id, e.g. "3" is track 3, with
+// lengths from frames and 1000 frames otherwise. "missing" fails to open.
+type fakeLibrary struct {
+	frames map[string]int64
+	frame  map[string]func(i int64) (left, right float32)
+	opened []string
+}
+
+func (l *fakeLibrary) open(path string) (Source, error) {
+	l.opened = append(l.opened, path)
+	var id float32
+	if _, err := fmt.Sscan(path, &id); err != nil {
+		return nil, fmt.Errorf("fake: no such track %q", path)
+	}
+	frames, ok := l.frames[path]
+	if !ok {
+		frames = 1000
+	}
+	return &fakeSource{id: id, frames: frames, frame: l.frame[path]}, nil
+}
+
+type fakeSink struct{ playing bool }
+
+func (s *fakeSink) Play()           { s.playing = true }
+func (s *fakeSink) Pause()          { s.playing = false }
+func (s *fakeSink) IsPlaying() bool { return s.playing }
+
+// Tracks with paths "1", "2" and so on
+func testTracks(ids ...int) []Track {
+	tracks := make([]Track, len(ids))
+	for i, id := range ids {
+		tracks[i] = Track{Name: fmt.Sprint(id), Path: fmt.Sprint(id)}
+	}
+	return tracks
+}
+
+func newTestEngine(t *testing.T) (*Engine, *fakeLibrary, *[]Event) {
+	t.Helper()
+	library := &fakeLibrary{frames: map[string]int64{}, frame: map[string]func(int64) (float32, float32){}}
+	e := New(library.open, testRate)
+	e.SetSink(&fakeSink{})
+	var events []Event
+	e.Subscribe(func(event Event) { events = append(events, event) })
+	t.Cleanup(func() { e.Close() })
+	return e, library, &events
+}
+
+type frame struct{ left, right float32 }
+
+// Pull frames from the engine the way the sink does, a chunk at a time,
+// calling Update between chunks
+func readFrames(e *Engine, frames, chunk int) []frame {
+	var out []frame
+	buf := make([]byte, chunk*BytesPerFrame)
+	for len(out) < frames {
+		e.Update()
+		n, _ := e.Read(buf[:min(chunk, frames-len(out))*BytesPerFrame])
+		for i := 0; i < n; i += BytesPerFrame {
+			out = append(out, frame{
+				math.Float32frombits(binary.LittleEndian.Uint32(buf[i:])),
+				math.Float32frombits(binary.LittleEndian.Uint32(buf[i+4:])),
+			})
+		}
+	}
+	e.Update()
+	return out
+}
+
+func countEvents(events []Event, kind EventKind) int {
+	n := 0
+	for _, event := range events {
+		if event.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGaplessHandoff(t *testing.T) {
+	e, library, _ := newTestEngine(t)
+	library.frames["1"] = 250
+	library.frames["2"] = 130
+	if err := e.Load(testTracks(1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks that don't line up with the track ends
+	got := readFrames(e, 250+130+10, 37)
+	for i, f := range got {
+		want := frame{1, float32(i)}
+		switch {
+		case i >= 380:
+			want = frame{3, float32(i - 380)}
+		case i >= 250:
+			want = frame{2, float32(i - 250)}
+		}
+		if f != want {
+			t.Fatalf("frame %d is %v, want %v", i, f, want)
+		}
+	}
+	if e.Current() != 2 {
+		t.Errorf("Current() = %d, want 2", e.Current())
+	}
+	if e.Position() != 100*time.Millisecond {
+		t.Errorf("Position() = %v, want 100ms", e.Position())
+	}
+}
+
+func TestCrossfadeIsEqualPower(t *testing.T) {
+	e, library, _ := newTestEngine(t)
+	// The first track is all left and the second all right, so each side
+	// of the mix shows one track's fade curve
+	library.frame["1"] = func(int64) (float32, float32) { return 1, 0 }
+	library.frame["2"] = func(int64) (float32, float32) { return 0, 1 }
+	e.SetCrossfade(time.Second)
+	if err := e.Load(testTracks(1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readFrames(e, 1000+50, 10)
+	for i := 0; i < 900; i++ {
+		if got[i] != (frame{1, 0}) {
+			t.Fatalf("frame %d is %v before the fade, want {1 0}", i, got[i])
+		}
+	}
+	for i := 900; i < 1000; i++ {
+		out, in := float64(got[i].left), float64(got[i].right)
+		if power := out*out + in*in; math.Abs(power-1) > 1e-5 {
+			t.Errorf("frame %d of the fade has power %.6f, want 1", i, power)
+		}
+		if i > 900 && (out > float64(got[i-1].left) || in < float64(got[i-1].right)) {
+			t.Errorf("frame %d: the fade isn't moving from the first track to the second", i)
+		}
+	}
+	if got[900].left < 0.999 || got[999].right < 0.999 {
+		t.Errorf("fade runs from %v to %v, want from all of the first track to all of the second", got[900], got[999])
+	}
+	// The second track carries on from where the fade got it to
+	if e.Current() != 1 || e.Position() != 1500*time.Millisecond {
+		t.Errorf("after the fade at track %d position %v, want track 1 at 1.5s", e.Current(), e.Position())
+	}
+	for i := 1000; i < len(got); i++ {
+		if got[i] != (frame{0, 1}) {
+			t.Fatalf("frame %d is %v after the fade, want {0 1}", i, got[i])
+		}
+	}
+}
+
+func TestRepeatModes(t *testing.T) {
+	t.Run("repeat one", func(t *testing.T) {
+		e, library, events := newTestEngine(t)
+		library.frames["1"] = 100
+		e.SetRepeat(RepeatOne)
+		e.Load(testTracks(1, 2))
+		got := readFrames(e, 250, 30)
+		for i, f := range got {
+			if want := (frame{1, float32(i % 100)}); f != want {
+				t.Fatalf("frame %d is %v, want %v", i, f, want)
+			}
+		}
+		if e.Current() != 0 || countEvents(*events, TrackChanged) != 3 {
+			t.Errorf("track %d after %d track changes, want track 0 and 3", e.Current(), countEvents(*events, TrackChanged))
+		}
+	})
+
+	t.Run("repeat all", func(t *testing.T) {
+		e, library, _ := newTestEngine(t)
+		library.frames["1"] = 100
+		library.frames["2"] = 100
+		e.Load(testTracks(1, 2))
+		got := readFrames(e, 250, 30)
+		if got[199] != (frame{2, 99}) || got[200] != (frame{1, 0}) {
+			t.Errorf("end of the list went %v then %v, want {2 99} then {1 0}", got[199], got[200])
+		}
+		if !e.IsPlaying() || e.Current() != 0 {
+			t.Errorf("playing %v at track %d, want playing track 0", e.IsPlaying(), e.Current())
+		}
+	})
+
+	t.Run("stop at end", func(t *testing.T) {
+		e, library, _ := newTestEngine(t)
+		library.frames["1"] = 100
+		library.frames["2"] = 100
+		e.SetRepeat(StopAtEnd)
+		e.Load(testTracks(1, 2))
+		got := readFrames(e, 250, 30)
+		for i := 200; i < 250; i++ {
+			if got[i] != (frame{}) {
+				t.Fatalf("frame %d is %v after the end, want silence", i, got[i])
+			}
+		}
+		// The list is lined up again from the top, waiting to be played
+		if e.IsPlaying() || e.Current() != 0 || e.Position() != 0 {
+			t.Errorf("playing %v at track %d position %v, want paused at the start of track 0",
+				e.IsPlaying(), e.Current(), e.Position())
+		}
+		e.Play()
+		if got := readFrames(e, 1, 1); got[0] != (frame{1, 0}) {
+			t.Errorf("playing again starts with %v, want {1 0}", got[0])
+		}
+	})
+}
+
+func TestShufflePlaysEachTrackOnce(t *testing.T) {
+	e, _, _ := newTestEngine(t)
+	e.SetShuffle(true)
+	e.Load(testTracks(1, 2, 3, 4, 5, 6))
+
+	played := []int{e.Current()}
+	for i := 0; i < 5; i++ {
+		if err := e.Next(); err != nil {
+			t.Fatal(err)
+		}
+		played = append(played, e.Current())
+	}
+	seen := map[int]bool{}
+	for _, index := range played {
+		if seen[index] {
+			t.Fatalf("played %v, track %d twice in one pass", played, index)
+		}
+		seen[index] = true
+	}
+
+	// Previous walks back through what was played
+	for i := len(played) - 2; i >= 0; i-- {
+		if err := e.Previous(); err != nil {
+			t.Fatal(err)
+		}
+		if e.Current() != played[i] {
+			t.Fatalf("Previous went to %d, want %d (played %v)", e.Current(), played[i], played)
+		}
+	}
+
+	// Tracks gone back over still get their turn, then a new pass starts
+	replayed := []int{e.Current()}
+	for i := 0; i < 5; i++ {
+		e.Next()
+		replayed = append(replayed, e.Current())
+	}
+	seen = map[int]bool{}
+	for _, index := range replayed {
+		seen[index] = true
+	}
+	if len(seen) != 6 {
+		t.Errorf("after going back, played %v, want all six tracks", replayed)
+	}
+}
+
+func TestPreviousWithoutShuffle(t *testing.T) {
+	e, _, _ := newTestEngine(t)
+	e.Load(testTracks(1, 2, 3))
+	e.Previous()
+	if e.Current() != 2 {
+		t.Errorf("Previous from the first track went to %d, want the last", e.Current())
+	}
+	e.Previous()
+	if e.Current() != 1 {
+		t.Errorf("Previous went to %d, want 1", e.Current())
+	}
+}
+
+func TestQueuePlaysBeforeTheList(t *testing.T) {
+	e, library, events := newTestEngine(t)
+	for _, path := range []string{"1", "2", "8", "9"} {
+		library.frames[path] = 100
+	}
+	e.Load(testTracks(1, 2))
+	e.Enqueue(testTracks(8)...)
+	e.PlayNext(testTracks(9)...)
+	if queue := e.Queue(); len(queue) != 2 || queue[0].Path != "9" || queue[1].Path != "8" {
+		t.Fatalf("Queue() = %v, want 9 then 8", queue)
+	}
+
+	got := readFrames(e, 350, 30)
+	for _, check := range []struct {
+		at   int
+		want frame
+	}{{99, frame{1, 99}}, {100, frame{9, 0}}, {200, frame{8, 0}}, {300, frame{2, 0}}} {
+		if got[check.at] != check.want {
+			t.Errorf("frame %d is %v, want %v", check.at, got[check.at], check.want)
+		}
+	}
+	if len(e.Queue()) != 0 || e.FromQueue() || e.Current() != 1 {
+		t.Errorf("queue %v from queue %v at track %d, want an empty queue and list track 1",
+			e.Queue(), e.FromQueue(), e.Current())
+	}
+	if countEvents(*events, QueueChanged) < 4 {
+		t.Errorf("%d QueueChanged events, want one for each change and each track leaving the queue",
+			countEvents(*events, QueueChanged))
+	}
+}
+
+func TestQueuedTrackIsNowPlaying(t *testing.T) {
+	e, _, _ := newTestEngine(t)
+	e.Load(testTracks(1, 2))
+	e.Enqueue(testTracks(7)...)
+	e.Next()
+	track, ok := e.NowPlaying()
+	if !ok || track.Path != "7" || !e.FromQueue() || e.Current() != 0 {
+		t.Errorf("playing %v (%v) from queue %v with list at %d, want track 7 from the queue and the list at 0",
+			track.Path, ok, e.FromQueue(), e.Current())
+	}
+	// Previous goes back to the list track the queue came in after
+	e.Previous()
+	if track, _ := e.NowPlaying(); track.Path != "1" || e.FromQueue() {
+		t.Errorf("Previous went to %v, want track 1", track.Path)
+	}
+}
+
+func TestMoveAndRemoveQueued(t *testing.T) {
+	e, _, _ := newTestEngine(t)
+	e.Load(testTracks(1))
+	e.Enqueue(testTracks(5, 6, 7, 8)...)
+	paths := func() string {
+		s := ""
+		for _, track := range e.Queue() {
+			s += track.Path
+		}
+		return s
+	}
+
+	e.MoveQueued(3, 0)
+	if got := paths(); got != "8567" {
+		t.Errorf("after moving the last to the front the queue is %s, want 8567", got)
+	}
+	e.MoveQueued(0, 2)
+	if got := paths(); got != "5687" {
+		t.Errorf("after moving the front down two the queue is %s, want 5687", got)
+	}
+	e.RemoveQueued(1)
+	if got := paths(); got != "587" {
+		t.Errorf("after removing the second the queue is %s, want 587", got)
+	}
+	// Out of range does nothing
+	e.MoveQueued(0, 3)
+	e.MoveQueued(-1, 0)
+	e.RemoveQueued(3)
+	if got := paths(); got != "587" {
+		t.Errorf("out of range changes left the queue as %s, want 587", got)
+	}
+
+	// A track already lined up to play next can still be moved
+	readFrames(e, 600, 50)
+	e.MoveQueued(2, 0)
+	if got := paths(); got != "758" {
+		t.Errorf("moving with the next track lined up left the queue as %s, want 758", got)
+	}
+	e.ClearQueue()
+	if got := paths(); got != "" {
+		t.Errorf("ClearQueue left %s", got)
+	}
+}
+
+func TestMoveTrack(t *testing.T) {
+	e, _, events := newTestEngine(t)
+	e.LoadAt(testTracks(1, 2, 3, 4), 1)
+	e.Update()
+	*events = nil
+	paths := func() string {
+		s := ""
+		for _, track := range e.Tracks() {
+			s += track.Path
+		}
+		return s
+	}
+
+	// Moving another track past the current one leaves it playing
+	e.MoveTrack(0, 1)
+	if got := paths(); got != "2134" || e.Current() != 0 {
+		t.Errorf("after moving the first down the list is %s with current %d, want 2134 and 0", got, e.Current())
+	}
+	e.MoveTrack(3, -1)
+	if got := paths(); got != "2143" || e.Current() != 0 {
+		t.Errorf("after moving the last up the list is %s with current %d, want 2143 and 0", got, e.Current())
+	}
+	e.MoveCurrent(1)
+	if got := paths(); got != "1243" || e.Current() != 1 {
+		t.Errorf("after moving the current down the list is %s with current %d, want 1243 and 1", got, e.Current())
+	}
+	// Off either end does nothing
+	e.MoveTrack(0, -1)
+	e.MoveTrack(3, 1)
+	e.MoveTrack(4, -1)
+	if got := paths(); got != "1243" {
+		t.Errorf("out of range moves left the list as %s, want 1243", got)
+	}
+	e.Update()
+	if n := countEvents(*events, ListChanged); n != 3 {
+		t.Errorf("%d ListChanged events, want 3", n)
+	}
+	if track, _ := e.NowPlaying(); track.Path != "2" || countEvents(*events, TrackChanged) != 0 {
+		t.Errorf("playing %s after moves, want track 2 all along", track.Path)
+	}
+}
+
+func TestSeekClamps(t *testing.T) {
+	e, _, events := newTestEngine(t)
+	if err := e.Seek(time.Second); err == nil {
+		t.Error("seeking with nothing loaded succeeded")
+	}
+	e.Load(testTracks(1))
+	for _, test := range []struct{ to, want time.Duration }{
+		{3 * time.Second, 3 * time.Second},
+		{-time.Second, 0},
+		{time.Hour, 10 * time.Second},
+		{2500 * time.Millisecond, 2500 * time.Millisecond},
+	} {
+		if err := e.Seek(test.to); err != nil {
+			t.Fatal(err)
+		}
+		if got := e.Position(); got != test.want {
+			t.Errorf("Seek(%v) went to %v, want %v", test.to, got, test.want)
+		}
+	}
+	if got := readFrames(e, 1, 1); got[0] != (frame{1, 250}) {
+		t.Errorf("after seeking the next frame is %v, want {1 250}", got[0])
+	}
+	if countEvents(*events, Seeked) != 4 {
+		t.Errorf("%d Seeked events, want 4", countEvents(*events, Seeked))
+	}
+}
+
+func TestLoadNothing(t *testing.T) {
+	e, _, _ := newTestEngine(t)
+	if err := e.Load(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.NowPlaying(); ok || e.IsPlaying() || e.Duration() != 0 {
+		t.Error("something is playing after loading no tracks")
+	}
+	e.Play()
+	e.TogglePlay()
+	e.Next()
+	e.Previous()
+	e.PlayTrack(0)
+	e.RemoveCurrent()
+	e.MoveCurrent(1)
+	for _, f := range readFrames(e, 20, 7) {
+		if f != (frame{}) {
+			t.Fatalf("got %v with nothing loaded, want silence", f)
+		}
+	}
+}
+
+func TestLoadAt(t *testing.T) {
+	for _, shuffle := range []bool{false, true} {
+		e, library, events := newTestEngine(t)
+		e.SetShuffle(shuffle)
+		e.Update()
+		*events = nil
+		if err := e.LoadAt(testTracks(1, 2, 3, 4), 2); err != nil {
+			t.Fatal(err)
+		}
+		// Only the start track is opened, with no sign of the first
+		if len(library.opened) != 1 || library.opened[0] != "3" {
+			t.Errorf("shuffle %v: opened %v, want only track 3", shuffle, library.opened)
+		}
+		got := readFrames(e, 10, 10)
+		if got[0].left != 3 || got[0].right != 0 {
+			t.Errorf("shuffle %v: started with %v, want track 3 from the start", shuffle, got[0])
+		}
+		if n := countEvents(*events, TrackChanged); n != 1 || e.Current() != 2 {
+			t.Errorf("shuffle %v: %d TrackChanged events and current %d, want 1 and 2", shuffle, n, e.Current())
+		}
+		if shuffle {
+			// The start track isn't played again before the rest
+			for range 3 {
+				e.Next()
+				if e.Current() == 2 {
+					t.Error("shuffle played the start track again before the others")
+				}
+			}
+		}
+	}
+
+	// A start outside the list plays the first track
+	e, _, _ := newTestEngine(t)
+	e.LoadAt(testTracks(1, 2), 5)
+	if e.Current() != 0 || !e.IsPlaying() {
+		t.Errorf("current %d, want the first track playing", e.Current())
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	e, library, events := newTestEngine(t)
+	if err := e.Load([]Track{{Path: "missing"}}); err == nil {
+		t.Error("loading a track that can't be opened succeeded")
+	}
+	if e.IsPlaying() || e.Duration() != 0 {
+		t.Error("playing a track that couldn't be opened")
+	}
+	readFrames(e, 10, 10)
+
+	// A track that fails part way through the list is reported and playback stops
+	library.frames["1"] = 100
+	e.Load([]Track{{Path: "1"}, {Path: "missing"}, {Path: "3"}})
+	*events = nil
+	got := readFrames(e, 150, 25)
+	if got[99] != (frame{1, 99}) || got[100] != (frame{}) {
+		t.Errorf("around the bad track got %v then %v, want {1 99} then silence", got[99], got[100])
+	}
+	if countEvents(*events, Error) != 1 || e.IsPlaying() {
+		t.Errorf("%d Error events and playing %v, want one error and stopped", countEvents(*events, Error), e.IsPlaying())
+	}
+	if err := e.Next(); err != nil || e.Current() != 2 || !e.IsPlaying() {
+		t.Errorf("Next after the bad track: %v, at %d, playing %v; want track 2 playing", err, e.Current(), e.IsPlaying())
+	}
+}
+
+func TestSlowOpenDoesNotBlock(t *testing.T) {
+	library := &fakeLibrary{frames: map[string]int64{}, frame: map[string]func(int64) (float32, float32){}}
+	started, release := make(chan struct{}), make(chan struct{})
+	var slow Source
+	e := New(func(path string) (Source, error) {
+		source, err := library.open(path)
+		if path == "2" {
+			slow = source
+			close(started)
+			<-release
+		}
+		return source, err
+	}, testRate)
+	t.Cleanup(func() { e.Close() })
+	e.Load(testTracks(1, 2, 3))
+
+	done := make(chan error)
+	go func() { done <- e.PlayTrack(1) }()
+	<-started
+	// The engine answers, and plays silence, while track 2 is opening
+	finished := make(chan []frame)
+	go func() {
+		e.Position()
+		finished <- readFrames(e, 10, 10)
+	}()
+	select {
+	case got := <-finished:
+		if got[0] != (frame{}) {
+			t.Errorf("got %v while opening, want silence", got[0])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the engine was locked while a track opened")
+	}
+
+	// Picking another track meanwhile wins over the slow one
+	if err := e.PlayTrack(2); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !slow.(*fakeSource).closed {
+		t.Error("the track opened too late wasn't closed")
+	}
+	if got := readFrames(e, 1, 1); e.Current() != 2 || got[0].left != 3 {
+		t.Errorf("at track %d playing %v, want track 3", e.Current(), got[0])
+	}
+}
@@ -0,0 +1,21 @@
+// Package enginetest has stand-in tracks for testing what's built on the
+// engine, so those tests needn't decode real files.
+package enginetest
+
+import (
+	"bytes"
+	"time"
+
+	"music/engine"
+)
+
+// Silence is a track of d of silence at sampleRate
+func Silence(d time.Duration, sampleRate int) engine.Source {
+	frames := int64(d.Seconds() * float64(sampleRate))
+	return silence{bytes.NewReader(make([]byte, frames*engine.BytesPerFrame))}
+}
+
+type silence struct{ *bytes.Reader }
+
+func (s silence) Length() int64 { return s.Size() }
+func (s silence) Close() error  { return nil }
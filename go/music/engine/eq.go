@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"encoding/binary"
+	"math"
+	"math/cmplx"
+)
+
+// EQFrequencies are the centres of the equalizer's ten bands, an octave apart
+var EQFrequencies = [10]float64{31, 62, 125, 250, 500, 1000, 2000, 4000, 8000, 16000}
+
+// EQGains are the boost or cut of each band in dB
+type EQGains [10]float64
+
+// EQPreset is a named set of band gains
+type EQPreset struct {
+	Name  string
+	Gains EQGains
+}
+
+// EQPresets are the built-in settings; the first is flat
+var EQPresets = []EQPreset{
+	{"Flat", EQGains{}},
+	{"Bass boost", EQGains{6, 5, 4, 2, 0, 0, 0, 0, 0, 0}},
+	{"Treble boost", EQGains{0, 0, 0, 0, 0, 1, 2, 4, 5, 6}},
+	{"Loudness", EQGains{5, 4, 2, 0, -1, -1, 0, 2, 4, 5}},
+	{"Vocal", EQGains{-3, -2, -1, 1, 3, 4, 3, 1, 0, -1}},
+	{"Rock", EQGains{4, 3, 2, 0, -1, -1, 1, 2, 3, 4}},
+	{"Pop", EQGains{-1, 1, 3, 4, 3, 0, -1, -1, 1, 2}},
+	{"Jazz", EQGains{3, 2, 1, 2, -1, -1, 0, 1, 2, 3}},
+	{"Classical", EQGains{4, 3, 2, 1, 0, 0, 0, 1, 2, 3}},
+}
+
+const eqQ = 1.41 // about an octave wide, so neighbouring bands meet
+
+// One peaking filter with its state for each channel
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     [2]float64
+}
+
+// Peaking EQ coefficients from the RBJ audio EQ cookbook
+func (f *biquad) setPeak(frequency, gain float64, sampleRate int) {
+	a := math.Pow(10, gain/40)
+	w := 2 * math.Pi * frequency / float64(sampleRate)
+	alpha := math.Sin(w) / (2 * eqQ)
+	a0 := 1 + alpha/a
+	f.b0 = (1 + alpha*a) / a0
+	f.b1 = -2 * math.Cos(w) / a0
+	f.b2 = (1 - alpha*a) / a0
+	f.a1 = f.b1
+	f.a2 = (1 - alpha/a) / a0
+}
+
+// Gain of the filter at angular frequency w, in radians per sample
+func (f *biquad) response(w float64) float64 {
+	z := cmplx.Exp(complex(0, -w)) // z⁻¹
+	numerator := complex(f.b0, 0) + complex(f.b1, 0)*z + complex(f.b2, 0)*z*z
+	denominator := 1 + complex(f.a1, 0)*z + complex(f.a2, 0)*z*z
+	return cmplx.Abs(numerator / denominator)
+}
+
+func (f *biquad) process(channel int, x float64) float64 {
+	y := f.b0*x + f.b1*f.x1[channel] + f.b2*f.x2[channel] - f.a1*f.y1[channel] - f.a2*f.y2[channel]
+	f.x2[channel], f.x1[channel] = f.x1[channel], x
+	f.y2[channel], f.y1[channel] = f.y1[channel], y
+	return y
+}
+
+// EQ is the gain of each equalizer band
+func (e *Engine) EQ() EQGains {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.eqGains
+}
+
+// SetEQ sets the equalizer bands, each clamped to ±12 dB. Everything is
+// turned down by the most any frequency is boosted, so boosts can't clip.
+func (e *Engine) SetEQ(gains EQGains) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range gains {
+		gains[i] = math.Max(-12, math.Min(gains[i], 12))
+		// Bands above the Nyquist frequency can't be filtered
+		if EQFrequencies[i] >= float64(e.sampleRate)/2 {
+			gains[i] = 0
+		}
+		e.eq[i].setPeak(EQFrequencies[i], gains[i], e.sampleRate)
+	}
+	e.eqGains = gains
+	e.eqPreGain = 1 / math.Max(1, e.eqPeak())
+	e.emit(ModeChanged)
+}
+
+// The most the bands boost any frequency by, as a linear gain. Neighbouring
+// bands overlap, so this can be more than the biggest single band.
+func (e *Engine) eqPeak() float64 {
+	peak := 0.0
+	nyquist := float64(e.sampleRate) / 2
+	// A few points per third of an octave from 20Hz up
+	for frequency := 20.0; frequency < nyquist; frequency *= math.Pow(2, 1.0/12) {
+		w := 2 * math.Pi * frequency / float64(e.sampleRate)
+		response := 1.0
+		for band := range e.eq {
+			if e.eqGains[band] != 0 {
+				response *= e.eq[band].response(w)
+			}
+		}
+		peak = math.Max(peak, response)
+	}
+	return peak
+}
+
+// Run samples through the equalizer; the lock is held
+func (e *Engine) equalize(samples []byte) {
+	if e.eqGains == (EQGains{}) {
+		return
+	}
+	for i := 0; i+4 <= len(samples); i += 4 {
+		channel := i / 4 % 2
+		v := float64(math.Float32frombits(binary.LittleEndian.Uint32(samples[i:]))) * e.eqPreGain
+		for band := range e.eq {
+			if e.eqGains[band] != 0 {
+				v = e.eq[band].process(channel, v)
+			}
+		}
+		binary.LittleEndian.PutUint32(samples[i:], math.Float32bits(float32(v)))
+	}
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// Stereo frames of a sine at frequency with the given peak
+func sine(frequency, peak float64, sampleRate int, seconds float64) []byte {
+	frames := int(seconds * float64(sampleRate))
+	samples := make([]byte, frames*BytesPerFrame)
+	for i := 0; i < frames; i++ {
+		v := float32(peak * math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate)))
+		binary.LittleEndian.PutUint32(samples[i*BytesPerFrame:], math.Float32bits(v))
+		binary.LittleEndian.PutUint32(samples[i*BytesPerFrame+4:], math.Float32bits(v))
+	}
+	return samples
+}
+
+// Level in dB of the last quarter of the left channel, once filters have settled
+func level(samples []byte) float64 {
+	frames := len(samples) / BytesPerFrame
+	var sum float64
+	n := 0
+	for i := frames * 3 / 4; i < frames; i++ {
+		v := float64(math.Float32frombits(binary.LittleEndian.Uint32(samples[i*BytesPerFrame:])))
+		sum += v * v
+		n++
+	}
+	return 10 * math.Log10(sum/float64(n))
+}
+
+func TestEQBandGainAtCentre(t *testing.T) {
+	const rate = 44100
+	for band, frequency := range EQFrequencies {
+		for _, gain := range []float64{6, -9} {
+			e := New(nil, rate)
+			var gains EQGains
+			gains[band] = gain
+			e.SetEQ(gains)
+
+			in := sine(frequency, 0.25, rate, 2)
+			out := append([]byte(nil), in...)
+			e.equalize(out)
+
+			// A boost is taken back off everything first to leave headroom
+			want := gain - math.Max(gain, 0)
+			if got := level(out) - level(in); math.Abs(got-want) > 0.25 {
+				t.Errorf("%g Hz band at %+g dB changed its centre by %+.2f dB, want %+.2f", frequency, gain, got, want)
+			}
+		}
+	}
+}
+
+func TestEQPreGain(t *testing.T) {
+	const rate = 44100
+	e := New(nil, rate)
+	e.SetEQ(EQGains{6, 5, 4, 2, 0, 0, 0, 0, 0, 0}) // the bass boost preset
+
+	// A full scale bass note stays within full scale
+	in := sine(62, 1, rate, 2)
+	out := append([]byte(nil), in...)
+	e.equalize(out)
+	peak := 0.0
+	for i := len(out) / 2; i+4 <= len(out); i += 4 {
+		peak = math.Max(peak, math.Abs(float64(math.Float32frombits(binary.LittleEndian.Uint32(out[i:])))))
+	}
+	if peak > 1.01 {
+		t.Errorf("boosted bass peaks at %.3f, want no more than full scale", peak)
+	}
+
+	// Bands that aren't boosted come down by at least the biggest boost
+	in = sine(4000, 0.25, rate, 1)
+	out = append([]byte(nil), in...)
+	e.equalize(out)
+	want := 20 * math.Log10(e.eqPreGain)
+	if got := level(out) - level(in); math.Abs(got-want) > 0.25 || want > -6 {
+		t.Errorf("4 kHz changed by %+.2f dB with a pre-gain of %+.2f dB, want the pre-gain and at most -6", got, want)
+	}
+
+	// Cuts alone need no headroom, and flat leaves samples alone
+	e.SetEQ(EQGains{-3, -3})
+	if e.eqPreGain != 1 {
+		t.Errorf("pre-gain with only cuts is %g, want 1", e.eqPreGain)
+	}
+	e.SetEQ(EQGains{})
+	out = append([]byte(nil), in...)
+	e.equalize(out)
+	if string(out) != string(in) {
+		t.Error("a flat EQ changed the samples")
+	}
+}
@@ -0,0 +1,43 @@
+package engine
+
+// EventKind says what changed
+type EventKind int
+
+const (
+	TrackChanged  EventKind = iota // a different track is current, or the current one was reopened
+	ListChanged                    // tracks were loaded, added, removed or moved
+	StateChanged                   // playback started or paused
+	Seeked                         // the position jumped
+	VolumeChanged                  // the volume changed
+	ModeChanged                    // shuffle or repeat changed
+	QueueChanged                   // tracks were queued, moved or removed, or one left the queue to play
+	GainMeasured                   // a track without gain tags was measured; Path and Gain say which and what
+	Error                          // a track could not be played; Err says why
+)
+
+// Event is sent to subscribers from Update
+type Event struct {
+	Kind  EventKind
+	Track int // current track when the event happened
+	Err   error
+
+	Path string     // the track measured, for GainMeasured
+	Gain ReplayGain // what it measured
+}
+
+// Subscribe calls f for every event. Events are delivered from Update, so
+// subscribers run on whichever goroutine drives the engine, never the audio one.
+func (e *Engine) Subscribe(f func(Event)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.subscribers = append(e.subscribers, f)
+}
+
+// Queue an event for the next Update; the lock must be held
+func (e *Engine) emit(kind EventKind) {
+	e.events = append(e.events, Event{Kind: kind, Track: e.current})
+}
+
+func (e *Engine) emitError(err error) {
+	e.events = append(e.events, Event{Kind: Error, Track: e.current, Err: err})
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// How long before the end of a track (plus any crossfade) the next one is opened
+const preloadAhead = 5 * time.Second
+
+// The track lined up to follow the current one. It is opened ahead of time so
+// the switch doesn't wait on the decoder, and read early while crossfading.
+type upcoming struct {
+	index     int
+	endOfList bool
+	queued    *Track // the front of the queue, which plays instead of the list's next
+	source    Source // nil until opened, or if opening failed
+	pos       int64  // bytes already read from source during a crossfade
+}
+
+// Crossfade is how long the end of one track overlaps the start of the next
+func (e *Engine) Crossfade() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.crossfade
+}
+
+// SetCrossfade sets the overlap between tracks; 0 plays them back to back
+func (e *Engine) SetCrossfade(d time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if d < 0 {
+		d = 0
+	}
+	e.crossfade = d
+	e.emit(ModeChanged)
+}
+
+func (e *Engine) durationToBytes(d time.Duration) int64 {
+	return int64(d.Seconds()*float64(e.sampleRate)) * BytesPerFrame
+}
+
+// Open the next track once the current one is nearly done. The decoder is
+// opened without holding the lock so the audio keeps flowing meanwhile.
+func (e *Engine) prepareNext() {
+	e.mu.Lock()
+	if e.next != nil || e.source == nil || e.repeat == RepeatOne ||
+		e.source.Length()-e.pos > e.durationToBytes(e.crossfade+preloadAhead) {
+		e.mu.Unlock()
+		return
+	}
+	next := e.pickNext()
+	if next == nil {
+		e.mu.Unlock()
+		return
+	}
+	e.next = next
+	path := e.upcomingTrack(next).Path
+	e.mu.Unlock()
+
+	source, err := e.open(path)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.next != next {
+		// The list or order changed while opening; the track isn't wanted now
+		if source != nil {
+			source.Close()
+		}
+		return
+	}
+	if err != nil {
+		// Leave it unopened; the switch tries again and reports the error then
+		return
+	}
+	next.source = source
+}
+
+// Pick what plays after the current track: the front of the play queue, or
+// else the list's next track. Nil when both are empty.
+func (e *Engine) pickNext() *upcoming {
+	if len(e.upNext) > 0 {
+		track := e.upNext[0]
+		e.upNext = e.upNext[1:]
+		return &upcoming{queued: &track}
+	}
+	if len(e.tracks) == 0 {
+		return nil
+	}
+	index, endOfList := e.following()
+	return &upcoming{index: index, endOfList: endOfList}
+}
+
+// Take the lined-up track, or pick one now if nothing was lined up
+func (e *Engine) takeNext() *upcoming {
+	next := e.next
+	e.next = nil
+	if next == nil {
+		next = e.pickNext()
+	}
+	return next
+}
+
+// The track an upcoming one plays
+func (e *Engine) upcomingTrack(next *upcoming) Track {
+	if next.queued != nil {
+		return *next.queued
+	}
+	return e.tracks[next.index]
+}
+
+// Forget the lined-up track, for when the list or play order changes. A
+// queued track goes back to the front of the play queue, and a shuffled pick
+// to the front of the shuffled order.
+func (e *Engine) dropNext() {
+	if e.next == nil {
+		return
+	}
+	if e.next.source != nil {
+		e.next.source.Close()
+	}
+	if e.next.queued != nil {
+		e.upNext = append([]Track{*e.next.queued}, e.upNext...)
+	} else if e.shuffle && e.next.index != e.current {
+		e.shuffleQueue = append([]int{e.next.index}, e.shuffleQueue...)
+	}
+	e.next = nil
+}
+
+// Make the lined-up track current, carrying on from wherever a crossfade got it to
+func (e *Engine) advanceTo(next *upcoming, play bool) error {
+	if next.queued != nil {
+		// The list stays where it was, to carry on from after the queue
+		e.queued = next.queued
+		e.emit(QueueChanged)
+	} else {
+		if e.shuffle {
+			e.history = append(e.history, e.current)
+		}
+		e.queued = nil
+		e.current = next.index
+	}
+	if next.source == nil {
+		return e.openCurrent(play)
+	}
+	e.closeSource()
+	e.source = next.source
+	e.pos = next.pos
+	e.playingTrack().Duration = e.bytesToDuration(next.source.Length())
+	if e.playing != play {
+		e.playing = play
+		e.emit(StateChanged)
+	}
+	e.emit(TrackChanged)
+	return nil
+}
+
+// Whether the end of the current track should fade into the lined-up one
+func (e *Engine) shouldFade(remaining, fade int64) bool {
+	next := e.next
+	if fade == 0 || remaining > fade || next == nil || next.source == nil ||
+		e.repeat == RepeatOne || next.endOfList && e.repeat == StopAtEnd {
+		return false
+	}
+	// Only fade over the whole window, not from part way through it after a seek
+	return next.pos > 0 || remaining >= fade
+}
+
+// Mix the start of the next track into samples, which hold the current track's
+// last bytes before remaining. Equal-power curves keep the loudness steady.
+func (e *Engine) mixNext(samples []byte, remaining, fade int64) {
+	if cap(e.mix) < len(samples) {
+		e.mix = make([]byte, len(samples))
+	}
+	mix := e.mix[:len(samples)]
+	read, _ := io.ReadFull(e.next.source, mix)
+	for i := read; i < len(mix); i++ {
+		mix[i] = 0
+	}
+	e.next.pos += int64(read)
+	scale(mix, e.trackGain(e.upcomingTrack(e.next)))
+
+	for frame := 0; frame+BytesPerFrame <= len(samples); frame += BytesPerFrame {
+		t := 1 - float64(remaining-int64(frame))/float64(fade)
+		t = math.Max(0, math.Min(t, 1))
+		out, in := float32(math.Cos(t*math.Pi/2)), float32(math.Sin(t*math.Pi/2))
+		for i := frame; i < frame+BytesPerFrame; i += 4 {
+			a := math.Float32frombits(binary.LittleEndian.Uint32(samples[i:]))
+			b := math.Float32frombits(binary.LittleEndian.Uint32(mix[i:]))
+			binary.LittleEndian.PutUint32(samples[i:], math.Float32bits(a*out+b*in))
+		}
+	}
+}
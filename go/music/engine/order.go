@@ -0,0 +1,263 @@
+package engine
+
+import (
+	"io"
+	"math/rand"
+)
+
+// RepeatMode decides what happens when a track or the whole list finishes
+type RepeatMode int
+
+const (
+	RepeatAll RepeatMode = iota // start the list again
+	RepeatOne                   // play the same track again
+	StopAtEnd                   // stop after the last track
+)
+
+var repeatModeNames = []string{"Repeat all", "Repeat one", "Stop at end"}
+
+func (m RepeatMode) String() string {
+	if m < 0 || int(m) >= len(repeatModeNames) {
+		return "Unknown"
+	}
+	return repeatModeNames[m]
+}
+
+// Next is the mode after m, for cycling through them with one key
+func (m RepeatMode) Next() RepeatMode {
+	return (m + 1) % RepeatMode(len(repeatModeNames))
+}
+
+func (e *Engine) Shuffle() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.shuffle
+}
+
+// SetShuffle turns shuffle on or off; a fresh shuffled order starts either way
+func (e *Engine) SetShuffle(shuffle bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dropNext()
+	e.shuffle = shuffle
+	e.resetOrder()
+	e.emit(ModeChanged)
+}
+
+func (e *Engine) Repeat() RepeatMode {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.repeat
+}
+
+func (e *Engine) SetRepeat(mode RepeatMode) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.repeat = mode
+	e.emit(ModeChanged)
+}
+
+// Forget the shuffled order and history, for when the list's indices change
+func (e *Engine) resetOrder() {
+	e.history = e.history[:0]
+	e.refillQueue()
+}
+
+// Queue everything but the current track, in random order
+func (e *Engine) refillQueue() {
+	e.shuffleQueue = e.shuffleQueue[:0]
+	if !e.shuffle {
+		return
+	}
+	for i := range e.tracks {
+		if i != e.current {
+			e.shuffleQueue = append(e.shuffleQueue, i)
+		}
+	}
+	rand.Shuffle(len(e.shuffleQueue), func(i, j int) {
+		e.shuffleQueue[i], e.shuffleQueue[j] = e.shuffleQueue[j], e.shuffleQueue[i]
+	})
+}
+
+// Pick the track after the current one and whether that starts a new pass of the list
+func (e *Engine) following() (next int, endOfList bool) {
+	if !e.shuffle {
+		next = (e.current + 1) % len(e.tracks)
+		return next, next == 0
+	}
+	// Each track plays once before any plays again
+	if len(e.shuffleQueue) == 0 {
+		e.refillQueue()
+		endOfList = true
+	}
+	if len(e.shuffleQueue) == 0 {
+		return e.current, endOfList // only one track
+	}
+	next = e.shuffleQueue[0]
+	e.shuffleQueue = e.shuffleQueue[1:]
+	return next, endOfList
+}
+
+// The current track ran out while the sink was reading; the lock is held
+func (e *Engine) trackEnded() {
+	if e.repeat == RepeatOne {
+		if _, err := e.source.Seek(0, io.SeekStart); err != nil {
+			e.emitError(err)
+			e.playing = false
+			e.emit(StateChanged)
+			return
+		}
+		e.pos = 0
+		e.emit(TrackChanged)
+		return
+	}
+
+	next := e.takeNext()
+	if next == nil {
+		// The queue ran out and there's no list to go back to
+		e.playing = false
+		e.emit(StateChanged)
+		return
+	}
+	// Line up the next pass but wait to be told to start it
+	play := !(next.endOfList && e.repeat == StopAtEnd)
+	if err := e.advanceTo(next, play); err != nil {
+		e.emitError(err)
+	}
+}
+
+// Next skips to the next track, from the queue if there is one, ignoring
+// repeat-one and stop-at-end
+func (e *Engine) Next() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	next := e.takeNext()
+	if next == nil {
+		return nil
+	}
+	return e.advanceTo(next, true)
+}
+
+// Previous goes back to the track played before this one when shuffling, or up the list otherwise
+func (e *Engine) Previous() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.tracks) == 0 {
+		return nil
+	}
+	e.dropNext()
+	if e.queued != nil {
+		// Back to the list track the queue came in after
+		e.queued = nil
+		return e.openCurrent(true)
+	}
+	if e.shuffle && len(e.history) > 0 {
+		// The current track goes back in the queue so it still gets its turn
+		e.shuffleQueue = append([]int{e.current}, e.shuffleQueue...)
+		e.current = e.history[len(e.history)-1]
+		e.history = e.history[:len(e.history)-1]
+	} else {
+		e.current = (e.current - 1 + len(e.tracks)) % len(e.tracks)
+	}
+	return e.openCurrent(true)
+}
+
+// PlayTrack jumps straight to a track, e.g. one picked from a list
+func (e *Engine) PlayTrack(index int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if index < 0 || index >= len(e.tracks) {
+		return nil
+	}
+	e.dropNext()
+	if e.shuffle {
+		e.history = append(e.history, e.current)
+		for i, queued := range e.shuffleQueue {
+			if queued == index {
+				e.shuffleQueue = append(e.shuffleQueue[:i], e.shuffleQueue[i+1:]...)
+				break
+			}
+		}
+	}
+	e.current = index
+	e.queued = nil
+	return e.openCurrent(true)
+}
+
+// Add appends a track to the end of the list, playing it if the list was empty
+func (e *Engine) Add(track Track) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dropNext()
+	e.tracks = append(e.tracks, track)
+	e.emit(ListChanged)
+	e.startMeasuring()
+	if len(e.tracks) == 1 && e.queued == nil {
+		e.current = 0
+		return e.openCurrent(true)
+	}
+	if e.shuffle {
+		e.shuffleQueue = append(e.shuffleQueue, len(e.tracks)-1)
+	}
+	return nil
+}
+
+// RemoveCurrent drops the current track from the list and plays the one after
+// it. A queued track isn't in the list, so there's nothing to remove then.
+func (e *Engine) RemoveCurrent() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.tracks) == 0 || e.queued != nil {
+		return nil
+	}
+	e.dropNext()
+	e.closeSource()
+	e.tracks = append(e.tracks[:e.current], e.tracks[e.current+1:]...)
+	if e.current >= len(e.tracks) {
+		e.current = 0
+	}
+	e.resetOrder()
+	e.emit(ListChanged)
+	if len(e.tracks) == 0 {
+		e.playing = false
+		e.emit(StateChanged)
+		e.emit(TrackChanged)
+		return nil
+	}
+	return e.openCurrent(true)
+}
+
+// MoveCurrent moves the current track up or down the list; it keeps playing
+func (e *Engine) MoveCurrent(delta int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.queued != nil {
+		return
+	}
+	e.moveTrack(e.current, delta)
+}
+
+// MoveTrack moves the track at index up or down the list, swapping it with
+// its neighbour. Whatever is playing carries on.
+func (e *Engine) MoveTrack(index, delta int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.moveTrack(index, delta)
+}
+
+func (e *Engine) moveTrack(index, delta int) {
+	to := index + delta
+	if index < 0 || index >= len(e.tracks) || to < 0 || to >= len(e.tracks) {
+		return
+	}
+	e.dropNext()
+	e.tracks[index], e.tracks[to] = e.tracks[to], e.tracks[index]
+	switch e.current {
+	case index:
+		e.current = to
+	case to:
+		e.current = index
+	}
+	e.resetOrder()
+	e.emit(ListChanged)
+}
@@ -0,0 +1,106 @@
+package engine
+
+// The play queue holds tracks to play next, ahead of the list's own order.
+// Queued tracks aren't part of the list: each plays once and leaves the queue,
+// then the list carries on after the track it was on.
+
+// Queue returns the tracks waiting to play, the next one first
+func (e *Engine) Queue() []Track {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var queue []Track
+	if e.next != nil && e.next.queued != nil {
+		queue = append(queue, *e.next.queued)
+	}
+	return append(queue, e.upNext...)
+}
+
+// Enqueue adds tracks to the end of the queue. If nothing is playing the first
+// of them starts straight away.
+func (e *Engine) Enqueue(tracks ...Track) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dropNext()
+	e.upNext = append(e.upNext, tracks...)
+	return e.queueChanged()
+}
+
+// PlayNext puts tracks at the front of the queue, to play after the current one
+func (e *Engine) PlayNext(tracks ...Track) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dropNext()
+	e.upNext = append(append([]Track(nil), tracks...), e.upNext...)
+	return e.queueChanged()
+}
+
+// RemoveQueued takes a track out of the queue
+func (e *Engine) RemoveQueued(index int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dropNext()
+	if index < 0 || index >= len(e.upNext) {
+		return
+	}
+	e.upNext = append(e.upNext[:index], e.upNext[index+1:]...)
+	e.emit(QueueChanged)
+}
+
+// MoveQueued moves a queued track to another place in the queue
+func (e *Engine) MoveQueued(from, to int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dropNext()
+	if from < 0 || from >= len(e.upNext) || to < 0 || to >= len(e.upNext) || from == to {
+		return
+	}
+	track := e.upNext[from]
+	e.upNext = append(e.upNext[:from], e.upNext[from+1:]...)
+	e.upNext = append(e.upNext[:to], append([]Track{track}, e.upNext[to:]...)...)
+	e.emit(QueueChanged)
+}
+
+// ClearQueue empties the queue; a queued track already playing carries on
+func (e *Engine) ClearQueue() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dropNext()
+	e.upNext = nil
+	e.emit(QueueChanged)
+}
+
+// NowPlaying is the track being played, whether from the list or the queue,
+// and false when there is none
+func (e *Engine) NowPlaying() (Track, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.queued == nil && len(e.tracks) == 0 {
+		return Track{}, false
+	}
+	return *e.playingTrack(), true
+}
+
+// FromQueue is whether the track playing came from the queue rather than the list
+func (e *Engine) FromQueue() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.queued != nil
+}
+
+// The track being played; the lock is held and there is one
+func (e *Engine) playingTrack() *Track {
+	if e.queued != nil {
+		return e.queued
+	}
+	return &e.tracks[e.current]
+}
+
+// Announce a change to the queue and start it if nothing is playing; the lock is held
+func (e *Engine) queueChanged() error {
+	e.emit(QueueChanged)
+	e.startMeasuring()
+	if e.source == nil && e.opening == 0 && len(e.upNext) > 0 {
+		return e.advanceTo(e.takeNext(), true)
+	}
+	return nil
+}
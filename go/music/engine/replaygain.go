@@ -0,0 +1,233 @@
+package engine
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// ReplayGain is how much to turn a track up or down so it plays at the same
+// loudness as others, from its tags or measured by the engine
+type ReplayGain struct {
+	TrackGain float64 // dB
+	TrackPeak float64 // largest sample, 1 being full scale; 0 when unknown
+	AlbumGain float64
+	AlbumPeak float64
+	HasTrack  bool
+	HasAlbum  bool
+}
+
+// GainMode picks which ReplayGain value is applied
+type GainMode int
+
+const (
+	GainOff   GainMode = iota
+	GainTrack          // every track at the same loudness
+	GainAlbum          // albums at the same loudness, keeping the differences within them
+)
+
+var gainModeNames = []string{"ReplayGain off", "Track gain", "Album gain"}
+
+func (m GainMode) String() string {
+	if m < 0 || int(m) >= len(gainModeNames) {
+		return "Unknown"
+	}
+	return gainModeNames[m]
+}
+
+// Next is the mode after m, for cycling through them with one key
+func (m GainMode) Next() GainMode {
+	return (m + 1) % GainMode(len(gainModeNames))
+}
+
+// Loudness tracks are brought to, as in ReplayGain 2.0
+const referenceLoudness = -18 // LUFS
+
+func (e *Engine) GainMode() GainMode {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.gainMode
+}
+
+// SetGainMode turns ReplayGain on or off. Tracks without gain tags are measured
+// in the background while it is on.
+func (e *Engine) SetGainMode(mode GainMode) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.gainMode = mode
+	e.emit(ModeChanged)
+	e.startMeasuring()
+}
+
+// Linear gain for a track in the current mode; the lock is held
+func (e *Engine) trackGain(track Track) float32 {
+	g := track.Gain
+	if measured, ok := e.measured[track.Path]; ok && !g.HasTrack {
+		g.TrackGain, g.TrackPeak, g.HasTrack = measured.TrackGain, measured.TrackPeak, measured.HasTrack
+	}
+	db, peak := 0.0, 0.0
+	switch {
+	case e.gainMode == GainOff:
+		return 1
+	case e.gainMode == GainAlbum && g.HasAlbum:
+		db, peak = g.AlbumGain, g.AlbumPeak
+	case g.HasTrack:
+		db, peak = g.TrackGain, g.TrackPeak
+	default:
+		return 1
+	}
+	gain := math.Pow(10, db/20)
+	// Never turn a track up so far that it clips
+	if peak > 0 && gain*peak > 1 {
+		gain = 1 / peak
+	}
+	return float32(gain)
+}
+
+// Measure tracks that have no gain tags, one at a time in the background; the
+// lock is held
+func (e *Engine) startMeasuring() {
+	if e.measuring || e.gainMode == GainOff {
+		return
+	}
+	e.measuring = true
+	go e.measure()
+}
+
+func (e *Engine) measure() {
+	for {
+		e.mu.Lock()
+		path := ""
+		for _, tracks := range [][]Track{e.tracks, e.upNext} {
+			for _, track := range tracks {
+				if _, done := e.measured[track.Path]; path == "" && !done && !track.Gain.HasTrack {
+					path = track.Path
+				}
+			}
+		}
+		if path == "" || e.gainMode == GainOff || e.closed {
+			e.measuring = false
+			e.mu.Unlock()
+			return
+		}
+		e.mu.Unlock()
+
+		var result ReplayGain
+		if source, err := e.open(path); err == nil {
+			gain, peak, err := MeasureLoudness(source, e.sampleRate)
+			source.Close()
+			if err == nil {
+				result = ReplayGain{TrackGain: gain, TrackPeak: peak, HasTrack: true}
+			}
+		}
+
+		e.mu.Lock()
+		if e.measured == nil {
+			e.measured = make(map[string]ReplayGain)
+		}
+		// Failures are remembered too, so they aren't tried again
+		e.measured[path] = result
+		// Let the measurement be kept, so it needn't be worked out again next time
+		if result.HasTrack {
+			e.events = append(e.events, Event{Kind: GainMeasured, Track: e.current, Path: path, Gain: result})
+		}
+		e.mu.Unlock()
+	}
+}
+
+// MeasureLoudness reads a whole stream of 32-bit float stereo samples and works
+// out its ReplayGain 2.0 track gain and peak. Loudness is measured as in EBU
+// R128: K-weighted, in gated 400ms blocks overlapping by 300ms.
+func MeasureLoudness(r io.Reader, sampleRate int) (gain, peak float64, err error) {
+	filters := kWeighting(sampleRate)
+	step := sampleRate / 10 // blocks are four of these 100ms steps
+	var steps []float64     // sum of squares of each step
+	var sum float64
+	count := 0
+
+	buf := make([]byte, 4096*BytesPerFrame)
+	for {
+		n, err := io.ReadFull(r, buf)
+		for i := 0; i+BytesPerFrame <= n; i += BytesPerFrame {
+			for channel := 0; channel < 2; channel++ {
+				v := float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i+4*channel:])))
+				peak = math.Max(peak, math.Abs(v))
+				for f := range filters {
+					v = filters[f].process(channel, v)
+				}
+				sum += v * v
+			}
+			if count++; count == step {
+				steps = append(steps, sum)
+				sum, count = 0, 0
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var blocks []float64 // mean square of each block
+	for i := 0; i+4 <= len(steps); i++ {
+		blocks = append(blocks, (steps[i]+steps[i+1]+steps[i+2]+steps[i+3])/float64(4*step))
+	}
+	loudness := func(meanSquare float64) float64 {
+		return -0.691 + 10*math.Log10(meanSquare)
+	}
+	gated := func(threshold float64) (float64, int) {
+		var total float64
+		n := 0
+		for _, b := range blocks {
+			if b > 0 && loudness(b) > threshold {
+				total += b
+				n++
+			}
+		}
+		return total, n
+	}
+
+	// Ignore silence, then anything much quieter than the rest
+	total, n := gated(-70)
+	if n == 0 {
+		return 0, peak, nil
+	}
+	total, n = gated(loudness(total/float64(n)) - 10)
+	if n == 0 {
+		return 0, peak, nil
+	}
+	return referenceLoudness - loudness(total/float64(n)), peak, nil
+}
+
+// The two K-weighting stages, a high shelf then a high pass, for any sample
+// rate; the formulas are the ones libebur128 uses
+func kWeighting(sampleRate int) []biquad {
+	rate := float64(sampleRate)
+
+	f0, g, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return []biquad{shelf, highPass}
+}
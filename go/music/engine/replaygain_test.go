@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeasureLoudness(t *testing.T) {
+	// EBU Tech 3341: a 1 kHz stereo sine peaking at -23 dBFS measures -23 LUFS,
+	// which is 5 dB below the ReplayGain reference
+	tests := []struct {
+		frequency, dbfs float64
+		rate            int
+	}{
+		{1000, -23, 48000},
+		{1000, -23, 44100},
+		{1000, -18, 44100},
+		{1000, -6, 44100},
+	}
+	for _, test := range tests {
+		peak := math.Pow(10, test.dbfs/20)
+		gain, gotPeak, err := MeasureLoudness(bytes.NewReader(sine(test.frequency, peak, test.rate, 5)), test.rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := referenceLoudness - test.dbfs
+		if math.Abs(gain-want) > 0.1 {
+			t.Errorf("%g dBFS at %d Hz: gain %.2f dB, want %.2f", test.dbfs, test.rate, gain, want)
+		}
+		if math.Abs(gotPeak-peak) > 1e-3 {
+			t.Errorf("%g dBFS at %d Hz: peak %.4f, want %.4f", test.dbfs, test.rate, gotPeak, peak)
+		}
+	}
+}
+
+func TestMeasureLoudnessGating(t *testing.T) {
+	const rate = 48000
+	// Silence on either side of the tone is gated out. Blocks only partly
+	// over the tone still count, so the gain comes out a little high.
+	tone := sine(1000, math.Pow(10, -23.0/20), rate, 3)
+	silence := make([]byte, 2*rate*BytesPerFrame)
+	stream := append(append(append([]byte(nil), silence...), tone...), silence...)
+	gain, _, err := MeasureLoudness(bytes.NewReader(stream), rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(gain-5) > 0.5 {
+		t.Errorf("tone between silences: gain %.2f dB, want 5", gain)
+	}
+
+	// All silence has nothing to measure
+	gain, peak, err := MeasureLoudness(bytes.NewReader(silence), rate)
+	if err != nil || gain != 0 || peak != 0 {
+		t.Errorf("silence: gain %g peak %g err %v, want 0, 0, nil", gain, peak, err)
+	}
+}
+
+func TestTrackGain(t *testing.T) {
+	e := New(nil, 44100)
+	track := Track{Path: "a", Gain: ReplayGain{TrackGain: -6, TrackPeak: 0.5, AlbumGain: 12, AlbumPeak: 0.5, HasTrack: true, HasAlbum: true}}
+	if got := e.trackGain(track); got != 1 {
+		t.Errorf("off: %g, want 1", got)
+	}
+	e.gainMode = GainTrack
+	if got := e.trackGain(track); math.Abs(float64(got)-math.Pow(10, -6.0/20)) > 1e-6 {
+		t.Errorf("track gain: %g, want -6 dB", got)
+	}
+	e.gainMode = GainAlbum
+	if got := e.trackGain(track); got != 2 {
+		t.Errorf("album gain of +12 dB with a peak of 0.5: %g, want it held to 2 so it doesn't clip", got)
+	}
+}
+
+func TestMeasuredGainIsReported(t *testing.T) {
+	peak := math.Pow(10, -23.0/20)
+	open := func(path string) (Source, error) {
+		return &fakeSource{frames: 44100, frame: func(i int64) (float32, float32) {
+			v := float32(peak * math.Sin(2*math.Pi*1000*float64(i)/44100))
+			return v, v
+		}}, nil
+	}
+	e := New(open, 44100)
+	t.Cleanup(func() { e.Close() })
+	var measured []Event
+	e.Subscribe(func(event Event) {
+		if event.Kind == GainMeasured {
+			measured = append(measured, event)
+		}
+	})
+
+	tracks := testTracks(1, 2)
+	tracks[1].Gain = ReplayGain{TrackGain: -3, HasTrack: true}
+	if err := e.Load(tracks); err != nil {
+		t.Fatal(err)
+	}
+	e.SetGainMode(GainTrack)
+	for deadline := time.Now().Add(5 * time.Second); len(measured) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		e.Update()
+	}
+
+	// Only the track without tags is measured, a sine at -23 dBFS coming out 5 dB quiet
+	if len(measured) != 1 || measured[0].Path != "1" || !measured[0].Gain.HasTrack ||
+		math.Abs(measured[0].Gain.TrackGain-5) > 0.5 {
+		t.Fatalf("got %+v, want one measurement of track 1 at about +5 dB", measured)
+	}
+}
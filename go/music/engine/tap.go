@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// How many of the most recent samples are kept for visualizers
+const tapSize = 4096
+
+// Keep a mono copy of samples on their way to the sink; the lock is held
+func (e *Engine) record(samples []byte) {
+	for i := 0; i+BytesPerFrame <= len(samples); i += BytesPerFrame {
+		left := math.Float32frombits(binary.LittleEndian.Uint32(samples[i:]))
+		right := math.Float32frombits(binary.LittleEndian.Uint32(samples[i+4:]))
+		e.tap[e.tapPos] = (left + right) / 2
+		e.tapPos = (e.tapPos + 1) % tapSize
+	}
+}
+
+// Record frames of silence, e.g. while paused
+func (e *Engine) recordSilence(frames int) {
+	for ; frames > 0; frames-- {
+		e.tap[e.tapPos] = 0
+		e.tapPos = (e.tapPos + 1) % tapSize
+	}
+}
+
+// Recent fills dst with the latest samples sent to the sink, oldest first, as
+// mono before the volume is applied, so a visualizer can watch the audio
+// without taking part in playback. At most 4096 samples are kept.
+func (e *Engine) Recent(dst []float32) []float32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(dst) > tapSize {
+		dst = dst[:tapSize]
+	}
+	start := e.tapPos - len(dst)
+	if start < 0 {
+		start += tapSize
+	}
+	for i := range dst {
+		dst[i] = e.tap[(start+i)%tapSize]
+	}
+	return dst
+}
+
+// SampleRate is the rate of the samples the engine produces
+func (e *Engine) SampleRate() int {
+	return e.sampleRate
+}
@@ -0,0 +1,27 @@
+package engine
+
+import "time"
+
+// Track is one entry in the play list
+type Track struct {
+	Name        string // file name, shown when the track has no title
+	Path        string
+	Duration    time.Duration // known once the track has been opened
+	Title       string
+	Artist      string
+	Album       string
+	TrackNumber int
+	Genre       string
+	Gain        ReplayGain // from the tags; tracks without are measured when ReplayGain is on
+}
+
+// DisplayName is the title from the tags, or the file name when the track isn't tagged
+func (t Track) DisplayName() string {
+	if t.Title == "" {
+		return t.Name
+	}
+	if t.Artist == "" {
+		return t.Title
+	}
+	return t.Artist + " - " + t.Title
+}
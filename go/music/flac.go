@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// FLAC decoding. Frames are decoded one at a time as the player reads, into the
+// same 32-bit float stereo layout the other decoders produce.
+
+var errFLACSync = errors.New("flac: lost frame sync")
+
+type flacInfo struct {
+	minBlockSize  int
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+	totalSamples  int64
+}
+
+// A SEEKTABLE entry: the frame starting at offset (from the first frame) holds sample
+type flacSeekPoint struct {
+	sample int64
+	offset int64
+}
+
+type flacFrameHeader struct {
+	firstSample   int64
+	blockSize     int
+	channels      int
+	assignment    int // 0-7 independent channels, 8 left/side, 9 side/right, 10 mid/side
+	bitsPerSample int
+	length        int // bytes in the header itself
+}
+
+type flacStream struct {
+	src        io.ReadSeeker
+	bits       flacBitReader
+	info       flacInfo
+	seekTable  []flacSeekPoint
+	audioStart int64 // file offset of the first frame
+	audioEnd   int64
+
+	buf        []byte // decoded bytes not yet read
+	nextSample int64  // first sample of the next frame to decode
+	pos        int64  // byte position of the next Read in the decoded stream
+}
+
+// Read the FLAC metadata and position the stream at the first frame
+func decodeFLAC(src io.ReadSeeker) (*flacStream, error) {
+	r := bufio.NewReader(src)
+	var offset int64
+	read := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		offset += int64(n)
+		return b, err
+	}
+
+	marker, err := read(4)
+	if err != nil {
+		return nil, err
+	}
+	if string(marker[:3]) == "ID3" {
+		rest, err := read(6)
+		if err != nil {
+			return nil, err
+		}
+		size := int64(synchsafe(rest[2:6]))
+		if rest[1]&0x10 != 0 {
+			size += 10 // footer
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			return nil, err
+		}
+		offset += size
+		if marker, err = read(4); err != nil {
+			return nil, err
+		}
+	}
+	if string(marker) != "fLaC" {
+		return nil, errors.New("flac: not a FLAC file")
+	}
+
+	s := &flacStream{src: src}
+	haveInfo := false
+	for last := false; !last; {
+		header, err := read(4)
+		if err != nil {
+			return nil, err
+		}
+		last = header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		block, err := read(length)
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case 0: // STREAMINFO
+			if length < 34 {
+				return nil, errors.New("flac: short STREAMINFO block")
+			}
+			packed := binary.BigEndian.Uint64(block[10:18])
+			s.info = flacInfo{
+				minBlockSize:  int(binary.BigEndian.Uint16(block[0:2])),
+				sampleRate:    int(packed >> 44),
+				channels:      int(packed>>41&0x7) + 1,
+				bitsPerSample: int(packed>>36&0x1F) + 1,
+				totalSamples:  int64(packed & 0xFFFFFFFFF),
+			}
+			haveInfo = true
+		case 3: // SEEKTABLE
+			for i := 0; i+18 <= length; i += 18 {
+				sample := binary.BigEndian.Uint64(block[i : i+8])
+				if sample == math.MaxUint64 {
+					continue // placeholder
+				}
+				s.seekTable = append(s.seekTable, flacSeekPoint{
+					sample: int64(sample),
+					offset: int64(binary.BigEndian.Uint64(block[i+8 : i+16])),
+				})
+			}
+		}
+	}
+	if !haveInfo {
+		return nil, errors.New("flac: missing STREAMINFO block")
+	}
+	if s.info.totalSamples == 0 {
+		return nil, errors.New("flac: stream length is unknown")
+	}
+
+	s.audioStart = offset
+	if s.audioEnd, err = src.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	if _, err := src.Seek(s.audioStart, io.SeekStart); err != nil {
+		return nil, err
+	}
+	s.bits.reset(src)
+	return s, nil
+}
+
+func (s *flacStream) Length() int64 {
+	return s.info.totalSamples * 8
+}
+
+func (s *flacStream) SampleRate() int {
+	return s.info.sampleRate
+}
+
+func (s *flacStream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.nextSample >= s.info.totalSamples {
+			return 0, io.EOF
+		}
+		if _, err := s.decodeFrame(); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return 0, io.EOF // truncated file, play what there was
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	s.pos += int64(n)
+	return n, nil
+}
+
+// Seek finds a frame at or before the target sample and decodes forward to it
+func (s *flacStream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.Length()
+	}
+	if offset < 0 {
+		return 0, errors.New("flac: negative position")
+	}
+	offset -= offset % 8
+	if offset == s.pos {
+		return s.pos, nil
+	}
+	target := offset / 8
+	if target >= s.info.totalSamples {
+		s.buf, s.nextSample, s.pos = nil, s.info.totalSamples, s.Length()
+		return s.pos, nil
+	}
+
+	if err := s.seekFrame(target); err != nil {
+		return 0, err
+	}
+	for {
+		first, err := s.decodeFrame()
+		if err != nil {
+			return 0, err
+		}
+		if target < s.nextSample {
+			s.buf = s.buf[(target-first)*8:]
+			break
+		}
+	}
+	s.pos = target * 8
+	return s.pos, nil
+}
+
+// Position the reader at the start of a frame holding target or an earlier sample
+func (s *flacStream) seekFrame(target int64) error {
+	// The seek table, when there is one, points straight at a frame
+	var best *flacSeekPoint
+	for i := range s.seekTable {
+		if s.seekTable[i].sample <= target && (best == nil || s.seekTable[i].sample > best.sample) {
+			best = &s.seekTable[i]
+		}
+	}
+	if best != nil {
+		if first, err := s.syncFrom(s.audioStart + best.offset); err == nil && first <= target {
+			return nil
+		}
+	}
+
+	// Otherwise guess from the average bitrate, backing off until the frame
+	// found comes before the target; the very first frame always does
+	span := s.audioEnd - s.audioStart
+	guess := s.audioStart + int64(float64(span)*float64(target)/float64(s.info.totalSamples))
+	for backoff := int64(16 * 1024); ; backoff *= 2 {
+		offset := guess - backoff
+		if offset <= s.audioStart {
+			_, err := s.syncFrom(s.audioStart)
+			return err
+		}
+		if first, err := s.syncFrom(offset); err == nil && first <= target {
+			return nil
+		}
+	}
+}
+
+// Move to offset and scan forward to the next valid frame header
+func (s *flacStream) syncFrom(offset int64) (int64, error) {
+	if _, err := s.src.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	s.bits.reset(s.src)
+	s.buf = nil
+	for {
+		header, err := s.peekHeader()
+		if err == nil {
+			s.nextSample = header.firstSample
+			return header.firstSample, nil
+		}
+		if err != errFLACSync {
+			return 0, err
+		}
+		if _, err := s.bits.r.Discard(1); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Parse the frame header at the reader's position without consuming it
+func (s *flacStream) peekHeader() (flacFrameHeader, error) {
+	h, err := s.bits.r.Peek(16)
+	if len(h) < 4 {
+		if err == nil {
+			err = io.EOF
+		}
+		return flacFrameHeader{}, err
+	}
+	if h[0] != 0xFF || h[1]&0xFE != 0xF8 {
+		return flacFrameHeader{}, errFLACSync
+	}
+	variable := h[1]&1 != 0
+	blockCode, rateCode := int(h[2]>>4), int(h[2]&0xF)
+	assignment, sizeCode := int(h[3]>>4), int(h[3]>>1&0x7)
+	if blockCode == 0 || rateCode == 15 || assignment > 10 || sizeCode == 3 || h[3]&1 != 0 {
+		return flacFrameHeader{}, errFLACSync
+	}
+
+	// Frame or sample number, UTF-8 style
+	i := 4
+	if i >= len(h) {
+		return flacFrameHeader{}, errFLACSync
+	}
+	var number int64
+	var extra int
+	switch c := h[i]; {
+	case c < 0x80:
+		number = int64(c)
+	case c&0xE0 == 0xC0:
+		number, extra = int64(c&0x1F), 1
+	case c&0xF0 == 0xE0:
+		number, extra = int64(c&0x0F), 2
+	case c&0xF8 == 0xF0:
+		number, extra = int64(c&0x07), 3
+	case c&0xFC == 0xF8:
+		number, extra = int64(c&0x03), 4
+	case c&0xFE == 0xFC:
+		number, extra = int64(c&0x01), 5
+	case c == 0xFE:
+		extra = 6
+	default:
+		return flacFrameHeader{}, errFLACSync
+	}
+	i++
+	for ; extra > 0; extra-- {
+		if i >= len(h) || h[i]&0xC0 != 0x80 {
+			return flacFrameHeader{}, errFLACSync
+		}
+		number = number<<6 | int64(h[i]&0x3F)
+		i++
+	}
+
+	header := flacFrameHeader{assignment: assignment, channels: assignment + 1}
+	if assignment >= 8 {
+		header.channels = 2
+	}
+	switch {
+	case blockCode == 1:
+		header.blockSize = 192
+	case blockCode <= 5:
+		header.blockSize = 576 << (blockCode - 2)
+	case blockCode == 6:
+		if i+1 > len(h) {
+			return flacFrameHeader{}, errFLACSync
+		}
+		header.blockSize = int(h[i]) + 1
+		i++
+	case blockCode == 7:
+		if i+2 > len(h) {
+			return flacFrameHeader{}, errFLACSync
+		}
+		header.blockSize = int(binary.BigEndian.Uint16(h[i:])) + 1
+		i += 2
+	default:
+		header.blockSize = 256 << (blockCode - 8)
+	}
+	// The frame's own sample rate must match STREAMINFO for playback, so it's only skipped
+	switch rateCode {
+	case 12:
+		i++
+	case 13, 14:
+		i += 2
+	}
+	header.bitsPerSample = [8]int{s.info.bitsPerSample, 8, 12, 0, 16, 20, 24, 32}[sizeCode]
+
+	if i >= len(h) || crc8(h[:i]) != h[i] {
+		return flacFrameHeader{}, errFLACSync
+	}
+	header.length = i + 1
+
+	if variable {
+		header.firstSample = number
+	} else {
+		header.firstSample = number * int64(s.info.minBlockSize)
+	}
+	return header, nil
+}
+
+// Decode the next frame into buf and return the number of its first sample
+func (s *flacStream) decodeFrame() (int64, error) {
+	header, err := s.peekHeader()
+	if err != nil {
+		return 0, err
+	}
+	s.bits.r.Discard(header.length)
+
+	channels := make([][]int64, header.channels)
+	for c := range channels {
+		bps := header.bitsPerSample
+		// The side channel needs one more bit than the others
+		if header.assignment == 8 && c == 1 || header.assignment == 9 && c == 0 || header.assignment == 10 && c == 1 {
+			bps++
+		}
+		if channels[c], err = s.subframe(bps, header.blockSize); err != nil {
+			return 0, err
+		}
+	}
+	s.bits.align()
+	if _, err := s.bits.read(16); err != nil { // CRC-16 of the frame
+		return 0, err
+	}
+
+	switch header.assignment {
+	case 8: // left/side
+		for i, side := range channels[1] {
+			channels[1][i] = channels[0][i] - side
+		}
+	case 9: // side/right
+		for i, side := range channels[0] {
+			channels[0][i] = side + channels[1][i]
+		}
+	case 10: // mid/side
+		for i, side := range channels[1] {
+			mid := channels[0][i]<<1 | side&1
+			channels[0][i] = (mid + side) >> 1
+			channels[1][i] = (mid - side) >> 1
+		}
+	}
+
+	// Mono plays on both sides; anything past stereo is dropped
+	left, right := channels[0], channels[0]
+	if len(channels) > 1 {
+		right = channels[1]
+	}
+	scale := float32(int64(1) << (header.bitsPerSample - 1))
+	out := make([]byte, header.blockSize*8)
+	for i := range left {
+		binary.LittleEndian.PutUint32(out[i*8:], math.Float32bits(float32(left[i])/scale))
+		binary.LittleEndian.PutUint32(out[i*8+4:], math.Float32bits(float32(right[i])/scale))
+	}
+	s.buf = out
+	s.nextSample = header.firstSample + int64(header.blockSize)
+	return header.firstSample, nil
+}
+
+func (s *flacStream) subframe(bps, n int) ([]int64, error) {
+	b := &s.bits
+	header, err := b.read(8)
+	if err != nil {
+		return nil, err
+	}
+	if header&0x80 != 0 {
+		return nil, errors.New("flac: bad subframe header")
+	}
+	kind := int(header >> 1 & 0x3F)
+	wasted := 0
+	if header&1 != 0 {
+		k, err := b.unary()
+		if err != nil {
+			return nil, err
+		}
+		wasted = k + 1
+		bps -= wasted
+	}
+
+	samples := make([]int64, n)
+	switch {
+	case kind == 0: // constant
+		v, err := b.signed(bps)
+		if err != nil {
+			return nil, err
+		}
+		for i := range samples {
+			samples[i] = v
+		}
+	case kind == 1: // verbatim
+		for i := range samples {
+			if samples[i], err = b.signed(bps); err != nil {
+				return nil, err
+			}
+		}
+	case kind >= 8 && kind <= 12: // fixed predictor
+		order := kind - 8
+		if err := s.warmup(samples, order, bps); err != nil {
+			return nil, err
+		}
+		if err := s.residual(samples, order); err != nil {
+			return nil, err
+		}
+		for i := order; i < n; i++ {
+			switch order {
+			case 1:
+				samples[i] += samples[i-1]
+			case 2:
+				samples[i] += 2*samples[i-1] - samples[i-2]
+			case 3:
+				samples[i] += 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+			case 4:
+				samples[i] += 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+			}
+		}
+	case kind >= 32: // linear prediction
+		order := kind - 31
+		if err := s.warmup(samples, order, bps); err != nil {
+			return nil, err
+		}
+		precision, err := b.read(4)
+		if err != nil {
+			return nil, err
+		}
+		if precision == 15 {
+			return nil, errors.New("flac: bad LPC precision")
+		}
+		shift, err := b.signed(5)
+		if err != nil {
+			return nil, err
+		}
+		if shift < 0 {
+			return nil, errors.New("flac: negative LPC shift")
+		}
+		coefficients := make([]int64, order)
+		for i := range coefficients {
+			if coefficients[i], err = b.signed(int(precision) + 1); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.residual(samples, order); err != nil {
+			return nil, err
+		}
+		for i := order; i < n; i++ {
+			var sum int64
+			for j, c := range coefficients {
+				sum += c * samples[i-1-j]
+			}
+			samples[i] += sum >> shift
+		}
+	default:
+		return nil, errors.New("flac: reserved subframe type")
+	}
+
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+	return samples, nil
+}
+
+// Read the unpredicted samples a predictor starts from
+func (s *flacStream) warmup(samples []int64, order, bps int) error {
+	if order > len(samples) {
+		return errors.New("flac: predictor order exceeds block size")
+	}
+	for i := 0; i < order; i++ {
+		v, err := s.bits.signed(bps)
+		if err != nil {
+			return err
+		}
+		samples[i] = v
+	}
+	return nil
+}
+
+// Read Rice coded residuals into samples after the warm-up samples
+func (s *flacStream) residual(samples []int64, order int) error {
+	b := &s.bits
+	method, err := b.read(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return errors.New("flac: reserved residual coding method")
+	}
+	paramBits := 4 + int(method)
+	escape := uint64(1)<<paramBits - 1
+
+	partitionOrder, err := b.read(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	perPartition := len(samples) >> partitionOrder
+	if perPartition < order {
+		return errors.New("flac: bad residual partition order")
+	}
+
+	i := order
+	for p := 0; p < partitions; p++ {
+		count := perPartition
+		if p == 0 {
+			count -= order
+		}
+		param, err := b.read(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			// Unencoded partition with its own sample size
+			size, err := b.read(5)
+			if err != nil {
+				return err
+			}
+			for ; count > 0; count-- {
+				if samples[i], err = b.signed(int(size)); err != nil {
+					return err
+				}
+				i++
+			}
+			continue
+		}
+		for ; count > 0; count-- {
+			high, err := b.unary()
+			if err != nil {
+				return err
+			}
+			low, err := b.read(int(param))
+			if err != nil {
+				return err
+			}
+			u := uint64(high)<<param | low
+			samples[i] = int64(u>>1) ^ -int64(u&1)
+			i++
+		}
+	}
+	return nil
+}
+
+// CRC-8 of a frame header, polynomial x^8 + x^2 + x + 1
+func crc8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Reads big-endian bit fields. Bytes are only taken from r as they are needed,
+// so between frames the reader sits exactly on a byte boundary.
+type flacBitReader struct {
+	r     *bufio.Reader
+	cache uint64
+	n     int // valid bits in cache
+}
+
+func (b *flacBitReader) reset(src io.Reader) {
+	if b.r == nil {
+		b.r = bufio.NewReaderSize(src, 64*1024)
+	} else {
+		b.r.Reset(src)
+	}
+	b.cache, b.n = 0, 0
+}
+
+func (b *flacBitReader) read(n int) (uint64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	var v uint64
+	// Take at most 32 bits at a time so the cache can't overflow
+	for n > 32 {
+		high, err := b.read(32)
+		if err != nil {
+			return 0, err
+		}
+		v = v<<32 | high
+		n -= 32
+	}
+	for b.n < n {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		b.cache = b.cache<<8 | uint64(c)
+		b.n += 8
+	}
+	b.n -= n
+	v = v<<n | b.cache>>b.n
+	b.cache &= 1<<b.n - 1
+	return v, nil
+}
+
+func (b *flacBitReader) signed(n int) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := b.read(n)
+	if err != nil {
+		return 0, err
+	}
+	shift := 64 - n
+	return int64(v<<shift) >> shift, nil
+}
+
+// Count zero bits up to and including the next one bit
+func (b *flacBitReader) unary() (int, error) {
+	count := 0
+	for {
+		if b.n == 0 {
+			c, err := b.r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			b.cache, b.n = uint64(c), 8
+		}
+		if b.cache == 0 {
+			count += b.n
+			b.n = 0
+			continue
+		}
+		length := bits.Len64(b.cache)
+		count += b.n - length
+		b.n = length - 1
+		b.cache &= 1<<b.n - 1
+		return count, nil
+	}
+}
+
+// Skip to the next byte boundary
+func (b *flacBitReader) align() {
+	b.n -= b.n % 8
+	b.cache &= 1<<b.n - 1
+}
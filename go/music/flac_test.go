@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The files in testdata/flac come from testdata/flac/generate.go. Between them
+// they have verbatim, constant, fixed and LPC subframes, wasted bits, Rice and
+// escaped residuals, every stereo mode, fixed and variable block sizes, a seek
+// table and an ID3 tag in front. Each .pcm file is the exact output expected.
+var flacFixtures = []struct {
+	name     string
+	channels int
+	bps      int
+	samples  int64
+}{
+	{"stereo16", 2, 16, 5000},
+	{"stereo24-variable", 2, 24, 5000},
+	{"mono8", 1, 8, 3000},
+}
+
+func openFLACFixture(t *testing.T, name string) (*flacStream, []byte) {
+	t.Helper()
+	file, err := os.Open(filepath.Join("testdata", "flac", name+".flac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	want, err := os.ReadFile(filepath.Join("testdata", "flac", name+".pcm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := decodeFLAC(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stream, want
+}
+
+// Report the first sample where got and want differ
+func comparePCM(t *testing.T, got, want []byte, firstSample int64) {
+	t.Helper()
+	if bytes.Equal(got, want) {
+		return
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d bytes, want %d", len(got), len(want))
+	}
+	for i := 0; i < len(got) && i < len(want); i++ {
+		if got[i] != want[i] {
+			t.Errorf("first difference at sample %d", firstSample+int64(i/8))
+			return
+		}
+	}
+}
+
+func TestDecodeFLAC(t *testing.T) {
+	for _, fixture := range flacFixtures {
+		t.Run(fixture.name, func(t *testing.T) {
+			stream, want := openFLACFixture(t, fixture.name)
+			if stream.info.channels != fixture.channels || stream.info.bitsPerSample != fixture.bps ||
+				stream.info.totalSamples != fixture.samples {
+				t.Errorf("STREAMINFO %+v, want %d channels of %d bits, %d samples",
+					stream.info, fixture.channels, fixture.bps, fixture.samples)
+			}
+			if stream.SampleRate() != 44100 || stream.Length() != fixture.samples*8 {
+				t.Errorf("rate %d length %d, want 44100 and %d", stream.SampleRate(), stream.Length(), fixture.samples*8)
+			}
+			got, err := io.ReadAll(stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			comparePCM(t, got, want, 0)
+		})
+	}
+}
+
+func TestSeekFLAC(t *testing.T) {
+	// Into the middle of a frame, onto frame boundaries, backwards, into the
+	// constant and wasted bits stretches and up to the last sample
+	targets := []int64{2500, 256, 1024, 4999, 1, 2100, 2700, 0, 3001, 1152 + 192}
+	for _, fixture := range flacFixtures {
+		t.Run(fixture.name, func(t *testing.T) {
+			stream, want := openFLACFixture(t, fixture.name)
+			for _, sample := range targets {
+				if sample >= fixture.samples {
+					continue
+				}
+				pos, err := stream.Seek(sample*8, io.SeekStart)
+				if err != nil {
+					t.Fatalf("seek to sample %d: %v", sample, err)
+				}
+				if pos != sample*8 {
+					t.Errorf("seek to sample %d landed at byte %d", sample, pos)
+				}
+				got := make([]byte, 600*8)
+				n, err := io.ReadFull(stream, got)
+				if err != nil && err != io.ErrUnexpectedEOF {
+					t.Fatalf("read after seeking to sample %d: %v", sample, err)
+				}
+				comparePCM(t, got[:n], want[sample*8:][:n], sample)
+			}
+		})
+	}
+}
+
+func TestSeekFLACRelative(t *testing.T) {
+	stream, want := openFLACFixture(t, "stereo16")
+
+	// Seeking rounds down to a whole frame of output
+	if pos, err := stream.Seek(1000*8+5, io.SeekStart); err != nil || pos != 1000*8 {
+		t.Fatalf("got %d, %v, want %d", pos, err, 1000*8)
+	}
+	if pos, err := stream.Seek(-100*8, io.SeekCurrent); err != nil || pos != 900*8 {
+		t.Fatalf("got %d, %v, want %d", pos, err, 900*8)
+	}
+	got := make([]byte, 8*8)
+	if _, err := io.ReadFull(stream, got); err != nil {
+		t.Fatal(err)
+	}
+	comparePCM(t, got, want[900*8:908*8], 900)
+
+	if pos, err := stream.Seek(-8, io.SeekEnd); err != nil || pos != stream.Length()-8 {
+		t.Fatalf("got %d, %v, want %d", pos, err, stream.Length()-8)
+	}
+	rest, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comparePCM(t, rest, want[len(want)-8:], 4999)
+
+	// Past the end is the end; before the start is an error
+	if pos, err := stream.Seek(stream.Length()+800, io.SeekStart); err != nil || pos != stream.Length() {
+		t.Errorf("got %d, %v, want the end at %d", pos, err, stream.Length())
+	}
+	if n, err := stream.Read(got); n != 0 || err != io.EOF {
+		t.Errorf("read at the end got %d, %v, want io.EOF", n, err)
+	}
+	if _, err := stream.Seek(-8, io.SeekStart); err == nil {
+		t.Error("seeking before the start succeeded")
+	}
+}
+
+// Without a seek table the stream must still find its way by scanning for frames
+func TestSeekFLACWithoutSeekTable(t *testing.T) {
+	stream, want := openFLACFixture(t, "stereo16")
+	stream.seekTable = nil
+	for _, sample := range []int64{4000, 300, 2600} {
+		if _, err := stream.Seek(sample*8, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 100*8)
+		if _, err := io.ReadFull(stream, got); err != nil {
+			t.Fatal(err)
+		}
+		comparePCM(t, got, want[sample*8:(sample+100)*8], sample)
+	}
+}
+
+func TestDecodeFLACRejects(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "flac", "stereo16.flac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]byte{
+		"not flac":   []byte("RIFF....WAVEfmt "),
+		"empty":      nil,
+		"no info":    append([]byte("fLaC"), 0x83, 0, 0, 0),
+		"cut header": data[:20],
+	}
+	for name, data := range tests {
+		if _, err := decodeFLAC(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: decoded without an error", name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/opentype"
+)
+
+// Fonts shipped in font/ are compiled into the binary so the player starts offline
+var (
+	//go:embed font/Poppins-SemiBold.ttf
+	poppinsFont []byte
+
+	//go:embed font/opendyslexic.ttf
+	openDyslexicFont []byte
+)
+
+// Bundled fonts by name, in the order the font switch cycles through them
+var (
+	fontNames    = []string{"poppins", "opendyslexic"}
+	bundledFonts = map[string][]byte{"poppins": poppinsFont, "opendyslexic": openDyslexicFont}
+)
+
+// Load your custom font
+var myFont font.Face
+
+// Load the UI font; name is one of the bundled fonts or a path to a font file
+func loadFont(name string, size float64) error {
+	fontBytes, bundled := bundledFonts[name]
+	if !bundled {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return fmt.Errorf("error reading font file: %w", err)
+		}
+		fontBytes = data
+	}
+
+	// Parse font
+	fnt, err := opentype.Parse(fontBytes)
+	if err != nil {
+		return fmt.Errorf("error parsing font: %w", err)
+	}
+
+	// Create font face
+	face, err := opentype.NewFace(fnt, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating font face: %w", err)
+	}
+
+	myFont = face
+	return nil
+}
+
+// Load the font from settings, then the default bundled one, then fall back to a plain bitmap font
+func applyFont(settings Settings) {
+	err := loadFont(settings.font(), settings.FontSize)
+	if err == nil {
+		return
+	}
+	fmt.Println("Error loading font:", err)
+	if loadFont(fontNames[0], settings.FontSize) != nil {
+		myFont = basicfont.Face7x13
+	}
+}
+
+// The bundled font after the given one; a custom font file goes back to the first
+func nextFont(name string) string {
+	for i, n := range fontNames {
+		if n == name {
+			return fontNames[(i+1)%len(fontNames)]
+		}
+	}
+	return fontNames[0]
+}
+
+// Height of a line of text in the current font
+func lineHeight() int {
+	return myFont.Metrics().Height.Ceil()
+}
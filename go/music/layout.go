@@ -0,0 +1,137 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+// Smallest window the layout still fits in
+const (
+	minWidth  = 560
+	minHeight = 420
+	margin    = 10
+	artSize   = 96
+	vizHeight = 48
+)
+
+// Key hints shown above the bottom buttons
+var keyHints = []string{
+	"Up & Down For Volume  / Filter  B Browse Library",
+	"Space Unpause/Pause  H Shuffle  R Repeat  C Crossfade",
+	", . Seek  Home Restart  Right-Click Queue  X Clear",
+	"F Font  +/- Size  T Theme  E EQ  G ReplayGain",
+	"A Add  Del Remove  Ctrl-Click & [ ] Move  N New  S Save",
+}
+
+// A button's bounds are set once per layout and used for both drawing and clicks
+type button struct {
+	bounds  image.Rectangle
+	label   string
+	color   func() color.Color // looked up when drawn so theme changes apply
+	onClick func() error
+}
+
+func (b *button) draw(screen *ebiten.Image) {
+	draw.Draw(screen, b.bounds, &image.Uniform{C: b.color()}, image.Point{}, draw.Src)
+	textY := b.bounds.Min.Y + (b.bounds.Dy()+lineHeight())/2 - myFont.Metrics().Descent.Ceil()
+	text.Draw(screen, b.label, myFont, b.bounds.Min.X+5, textY, theme.ButtonText)
+}
+
+// Where everything goes for the current window size
+type layout struct {
+	progressBar image.Rectangle
+	trackList   image.Rectangle // the filter box is its first row
+	queue       image.Rectangle // the play queue, beside the track list
+	volumeBar   image.Rectangle
+	artwork     image.Rectangle
+	spectrum    image.Rectangle
+	waveform    image.Rectangle
+	status      image.Point // baselines of single lines of text
+	hints       image.Point
+	mode        image.Point
+	volume      image.Point
+
+	play, pause, changeDirectory *button
+}
+
+// Create the buttons; arrange gives them their bounds
+func (p *Player) newLayout() layout {
+	return layout{
+		play: &button{
+			label:   ">",
+			color:   func() color.Color { return theme.PlayButton },
+			onClick: func() error { p.engine.TogglePlay(); return nil },
+		},
+		pause: &button{
+			label:   "||",
+			color:   func() color.Color { return theme.PauseButton },
+			onClick: func() error { p.engine.TogglePlay(); return nil },
+		},
+		changeDirectory: &button{
+			label:   "Change Directory",
+			color:   func() color.Color { return theme.ChangeDir },
+			onClick: func() error { p.changeDirectory(); return nil },
+		},
+	}
+}
+
+func (l *layout) buttons() []*button {
+	return []*button{l.play, l.pause, l.changeDirectory}
+}
+
+// Lay everything out for a window size. The track list takes whatever height
+// is left between the progress bar and the controls along the bottom.
+func (l *layout) arrange(width, height int) {
+	rh := rowHeight()
+
+	// Bottom right: the directory button, which opens playlists too
+	buttonY := height - buttonHeight - 5
+	l.changeDirectory.bounds = image.Rect(width-margin-buttonWidth, buttonY, width-margin, buttonY+buttonHeight)
+
+	// Bottom left: volume bar, play and pause
+	controlsY := height - 80
+	l.volumeBar = image.Rect(margin, controlsY, margin+10, height-20)
+	l.play.bounds = image.Rect(30, controlsY, 70, controlsY+30)
+	l.pause.bounds = l.play.bounds.Add(image.Pt(50, 0))
+	l.volume = image.Pt(25, height-35)
+	l.mode = image.Pt(30, height-10)
+
+	// Key hints stacked above the buttons
+	l.hints = image.Pt(220, buttonY-8-(len(keyHints)-1)*rh)
+
+	// Top: progress bar and status, the spectrum and waveform side by side,
+	// then the list and the play queue with the cover art beside them
+	l.progressBar = image.Rect(margin, 50, width-margin, 60)
+	l.status = image.Pt(150, 75)
+	l.spectrum = image.Rect(margin, 85, width/2-margin/2, 85+vizHeight)
+	l.waveform = image.Rect(width/2+margin/2, 85, width-margin, 85+vizHeight)
+	listTop := l.spectrum.Max.Y + margin
+	l.artwork = image.Rect(width-margin-artSize, listTop, width-margin, listTop+artSize)
+	listBottom := l.hints.Y - rh - 5
+	if controlsY-30 < listBottom {
+		listBottom = controlsY - 30 // leave room for the volume message
+	}
+	listRight := l.artwork.Min.X - margin
+	queueWidth := (listRight - margin) * 2 / 5
+	l.queue = image.Rect(listRight-queueWidth, listTop, listRight, listBottom)
+	l.trackList = image.Rect(margin, listTop, l.queue.Min.X-margin, listBottom)
+}
+
+// Run the action of a clicked button
+func (p *Player) clickButtons() error {
+	if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return nil
+	}
+	mouseX, mouseY := ebiten.CursorPosition()
+	for _, b := range p.ui.buttons() {
+		if image.Pt(mouseX, mouseY).In(b.bounds) {
+			return b.onClick()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"music/engine"
+)
+
+// How long the folder has to be quiet after a change before it is rescanned
+const rescanDelay = time.Second
+
+// Bumped when entries gain fields, so an older index is rebuilt rather than
+// leaving them empty for files that haven't changed
+const libraryVersion = 2
+
+// The index as saved on disk
+type libraryFile struct {
+	Version int            `json:"version"`
+	Files   []libraryEntry `json:"files"`
+}
+
+// What the library index remembers about one file. Files whose size and
+// modification time haven't changed aren't read again on a rescan.
+type libraryEntry struct {
+	Path        string            `json:"path"`
+	ModTime     time.Time         `json:"mtime"`
+	Size        int64             `json:"size"`
+	Title       string            `json:"title,omitempty"`
+	Artist      string            `json:"artist,omitempty"`
+	Album       string            `json:"album,omitempty"`
+	TrackNumber int               `json:"track_number,omitempty"`
+	Genre       string            `json:"genre,omitempty"`
+	Duration    time.Duration     `json:"duration"`
+	Gain        engine.ReplayGain `json:"gain"`
+}
+
+func (e libraryEntry) track() engine.Track {
+	return engine.Track{
+		Name:        filepath.Base(e.Path),
+		Path:        e.Path,
+		Duration:    e.Duration,
+		Title:       e.Title,
+		Artist:      e.Artist,
+		Album:       e.Album,
+		TrackNumber: e.TrackNumber,
+		Genre:       e.Genre,
+		Gain:        e.Gain,
+	}
+}
+
+// The library is an index of every file in the folders that have been opened,
+// kept on disk between runs. Scanning and watching for changes happen in the
+// background; the UI polls for progress and picks up changes from update.
+type library struct {
+	mu         sync.Mutex
+	entries    map[string]libraryEntry
+	root       string // folder being scanned and watched, empty when none
+	generation int    // bumped when the root changes so an old scan gives up
+	watcher    *fsnotify.Watcher
+	rescan     *time.Timer
+
+	scanning    bool
+	again       bool // files changed during the scan, so go round once more
+	found, done int  // files the scan found and how many it has indexed
+	changed     bool // tracks under root changed since the UI last looked
+	version     int  // counts every change to the entries, for views built from them
+}
+
+// Location of the index, next to the settings file
+func libraryPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "skyes-music-player", "library.json"), nil
+}
+
+// Load the index saved last time; a missing or unreadable one starts empty
+func loadLibrary() *library {
+	l := &library{entries: make(map[string]libraryEntry)}
+	path, err := libraryPath()
+	if err != nil {
+		return l
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return l
+	}
+	var index libraryFile
+	if json.Unmarshal(data, &index) == nil && index.Version == libraryVersion {
+		for _, entry := range index.Files {
+			// Only the open folder is rescanned, so files deleted from the
+			// others since the last run are dropped here
+			if _, err := os.Stat(entry.Path); errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			l.entries[entry.Path] = entry
+		}
+	}
+	return l
+}
+
+func (l *library) save() error {
+	l.mu.Lock()
+	entries := make([]libraryEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, entry)
+	}
+	l.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	path, err := libraryPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(libraryFile{Version: libraryVersion, Files: entries})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Start scanning and watching a folder, returning what the index already knows
+// about it so there's something to play straight away
+func (l *library) open(root string) []engine.Track {
+	l.stop()
+	l.mu.Lock()
+	l.root = root
+	l.generation++
+	generation := l.generation
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Println("Error watching folder:", err)
+	} else {
+		l.watcher = watcher
+		go l.watch(watcher, generation)
+	}
+	l.startScan()
+	l.mu.Unlock()
+	return l.tracks()
+}
+
+// Stop scanning and watching, e.g. when a playlist is opened instead
+func (l *library) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.root = ""
+	l.generation++
+	l.scanning, l.again = false, false // a scan still running gives up by itself
+	if l.watcher != nil {
+		l.watcher.Close()
+		l.watcher = nil
+	}
+	if l.rescan != nil {
+		l.rescan.Stop()
+	}
+}
+
+// Tracks under the root folder, albums in order
+func (l *library) tracks() []engine.Track {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var tracks []engine.Track
+	for path, entry := range l.entries {
+		if l.root != "" && inFolder(path, l.root) {
+			tracks = append(tracks, entry.track())
+		}
+	}
+	sortTracks(tracks)
+	return tracks
+}
+
+// Every track in the library, from all the folders that have been scanned,
+// and the version of the entries they came from
+func (l *library) all() ([]engine.Track, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tracks := make([]engine.Track, 0, len(l.entries))
+	for _, entry := range l.entries {
+		tracks = append(tracks, entry.track())
+	}
+	sortTracks(tracks)
+	return tracks, l.version
+}
+
+// The version of the entries, which changes whenever they do
+func (l *library) currentVersion() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.version
+}
+
+// Whether the tracks changed since the last call
+func (l *library) takeChanged() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	changed := l.changed
+	l.changed = false
+	return changed
+}
+
+// Keep a gain the engine measured for a file without gain tags, so it isn't
+// measured again next run. It's saved with the rest of the index.
+func (l *library) setGain(path string, gain engine.ReplayGain) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if entry, ok := l.entries[path]; ok && !entry.Gain.HasTrack {
+		entry.Gain.TrackGain, entry.Gain.TrackPeak, entry.Gain.HasTrack = gain.TrackGain, gain.TrackPeak, true
+		l.entries[path] = entry
+		l.version++
+	}
+}
+
+// How far the current scan has got
+func (l *library) progress() (scanning bool, done, found int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.scanning, l.done, l.found
+}
+
+// Scan the root, or go round again once the scan under way finishes; the lock is held
+func (l *library) startScan() {
+	if l.root == "" {
+		return
+	}
+	if l.scanning {
+		l.again = true
+		return
+	}
+	l.scanning = true
+	l.found, l.done = 0, 0
+	go l.scan(l.root, l.generation)
+}
+
+func (l *library) scan(root string, generation int) {
+	for {
+		if !l.scanOnce(root, generation) {
+			return
+		}
+		if err := l.save(); err != nil {
+			fmt.Println("Error saving library:", err)
+		}
+
+		l.mu.Lock()
+		if l.generation != generation {
+			l.mu.Unlock()
+			return
+		}
+		if !l.again {
+			l.scanning = false
+			l.mu.Unlock()
+			return
+		}
+		l.again = false
+		l.found, l.done = 0, 0
+		l.mu.Unlock()
+	}
+}
+
+// Index new and changed files under root and forget removed ones. Reports
+// false if the library moved on to another folder part way through.
+func (l *library) scanOnce(root string, generation int) bool {
+	current := func() bool { return l.generation == generation }
+
+	// Find the files first so progress can be shown against a total
+	var files, dirs []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip what can't be read
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+		} else if isAudioFile(d.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	l.mu.Lock()
+	if !current() {
+		l.mu.Unlock()
+		return false
+	}
+	l.found = len(files)
+	// fsnotify doesn't watch subfolders, so each one is added
+	if l.watcher != nil {
+		for _, dir := range dirs {
+			l.watcher.Add(dir)
+		}
+	}
+	l.mu.Unlock()
+
+	seen := make(map[string]bool, len(files))
+	for _, path := range files {
+		seen[path] = true
+		info, err := os.Stat(path)
+
+		l.mu.Lock()
+		if !current() {
+			l.mu.Unlock()
+			return false
+		}
+		old, known := l.entries[path]
+		l.mu.Unlock()
+
+		if err == nil && (!known || !old.ModTime.Equal(info.ModTime()) || old.Size != info.Size()) {
+			entry := indexFile(path, info)
+			l.mu.Lock()
+			l.entries[path] = entry
+			l.changed = true
+			l.version++
+			l.mu.Unlock()
+		}
+
+		l.mu.Lock()
+		if current() {
+			l.done++
+		}
+		l.mu.Unlock()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !current() {
+		return false
+	}
+	for path := range l.entries {
+		if inFolder(path, root) && !seen[path] {
+			delete(l.entries, path)
+			l.changed = true
+			l.version++
+		}
+	}
+	return true
+}
+
+// Read a file's tags and length for the index
+func indexFile(path string, info os.FileInfo) libraryEntry {
+	entry := libraryEntry{Path: path, ModTime: info.ModTime(), Size: info.Size()}
+	if tags, err := readTags(path, false); err == nil {
+		entry.Title = tags.Title
+		entry.Artist = tags.Artist
+		entry.Album = tags.Album
+		entry.TrackNumber = tags.TrackNumber
+		entry.Genre = tags.Genre
+		entry.Gain = tags.Gain
+	}
+	if file, err := os.Open(path); err == nil {
+		if stream, err := openStream(file, path); err == nil && stream.SampleRate() > 0 {
+			frames := stream.Length() / engine.BytesPerFrame
+			entry.Duration = time.Duration(frames) * time.Second / time.Duration(stream.SampleRate())
+		}
+		file.Close()
+	}
+	return entry
+}
+
+// Rescan once the folder has been quiet for a moment after files change
+func (l *library) watch(watcher *fsnotify.Watcher, generation int) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			l.mu.Lock()
+			if l.generation == generation {
+				if l.rescan != nil {
+					l.rescan.Stop()
+				}
+				l.rescan = time.AfterFunc(rescanDelay, func() {
+					l.mu.Lock()
+					defer l.mu.Unlock()
+					if l.generation == generation {
+						l.startScan()
+					}
+				})
+			}
+			l.mu.Unlock()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			fmt.Println("Error watching folder:", err)
+		}
+	}
+}
+
+// Whether path is somewhere inside folder
+func inFolder(path, folder string) bool {
+	rel, err := filepath.Rel(folder, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Play albums in order rather than in the order files happen to be found
+func sortTracks(tracks []engine.Track) {
+	sort.SliceStable(tracks, func(i, j int) bool {
+		a, b := tracks[i], tracks[j]
+		if a.Album != b.Album {
+			return a.Album < b.Album
+		}
+		if a.TrackNumber != b.TrackNumber {
+			return a.TrackNumber < b.TrackNumber
+		}
+		return a.Path < b.Path
+	})
+}
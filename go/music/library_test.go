@@ -0,0 +1,242 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"music/engine"
+)
+
+// Copy a FLAC fixture into dir under name
+func copyFixture(t *testing.T, fixture, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "flac", fixture+".flac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Wait for the library's tracks to satisfy ok
+func waitForTracks(t *testing.T, l *library, what string, ok func([]engine.Track) bool) []engine.Track {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		scanning, _, _ := l.progress()
+		tracks := l.tracks()
+		if !scanning && ok(tracks) {
+			return tracks
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("gave up waiting for %s, have %d tracks", what, len(tracks))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func hasTrack(tracks []engine.Track, path string) bool {
+	for _, track := range tracks {
+		if track.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLibrarySaveAndLoad(t *testing.T) {
+	path := tempSettings(t)
+	path = filepath.Join(filepath.Dir(path), "library.json")
+
+	music := t.TempDir()
+	l := &library{entries: map[string]libraryEntry{}}
+	entry := libraryEntry{
+		Path: copyFixture(t, "stereo16", music, "a.flac"), ModTime: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Size: 1234,
+		Title: "Song", Album: "Record", TrackNumber: 2, Duration: 3 * time.Second,
+		Gain: engine.ReplayGain{TrackGain: -4, TrackPeak: 0.8, HasTrack: true},
+	}
+	l.entries[entry.Path] = entry
+	b := copyFixture(t, "mono8", music, "b.flac")
+	l.entries[b] = libraryEntry{Path: b}
+	// A file deleted since is left out when the index is loaded
+	gone := filepath.Join(music, "gone.flac")
+	l.entries[gone] = libraryEntry{Path: gone}
+	if err := l.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := loadLibrary()
+	if _, ok := loaded.entries[gone]; ok || len(loaded.entries) != 2 {
+		t.Fatalf("loaded %d entries, want the 2 whose files are there", len(loaded.entries))
+	}
+	if got := loaded.entries[entry.Path]; !got.ModTime.Equal(entry.ModTime) || got.Size != entry.Size ||
+		got.Title != entry.Title || got.TrackNumber != entry.TrackNumber || got.Duration != entry.Duration ||
+		got.Gain != entry.Gain {
+		t.Errorf("loaded %+v, want %+v", got, entry)
+	}
+
+	// An index from another version is rebuilt rather than trusted, and a
+	// broken one is no index at all
+	for _, data := range []string{`{"version": 1, "files": [{"path": "/music/a.flac"}]}`, `{"version": `} {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if loaded := loadLibrary(); len(loaded.entries) != 0 {
+			t.Errorf("%s: loaded %d entries, want none", data, len(loaded.entries))
+		}
+	}
+}
+
+func TestLibraryScan(t *testing.T) {
+	tempSettings(t)
+	root := t.TempDir()
+	first := copyFixture(t, "stereo16", root, "first.flac")
+	nested := copyFixture(t, "mono8", root, filepath.Join("sub", "nested.flac"))
+	os.WriteFile(filepath.Join(root, "cover.jpg"), []byte("not audio"), 0o644)
+
+	l := loadLibrary()
+	t.Cleanup(l.stop)
+	l.open(root)
+	tracks := waitForTracks(t, l, "the first scan", func(tracks []engine.Track) bool { return len(tracks) == 2 })
+	if !hasTrack(tracks, first) || !hasTrack(tracks, nested) {
+		t.Fatalf("got %v, want %s and %s", tracks, first, nested)
+	}
+	if entry := l.entries[first]; entry.Duration != 5000*time.Second/44100 || entry.Size == 0 {
+		t.Errorf("indexed %+v, want its length and size", entry)
+	}
+	if _, done, found := l.progress(); done != 2 || found != 2 {
+		t.Errorf("progress %d of %d, want 2 of 2", done, found)
+	}
+
+	// The index was saved, and a second run knows the files before scanning
+	if again := loadLibrary(); len(again.entries) != 2 {
+		t.Errorf("saved index has %d entries, want 2", len(again.entries))
+	}
+}
+
+func TestLibraryWatchesForChanges(t *testing.T) {
+	tempSettings(t)
+	root := t.TempDir()
+	copyFixture(t, "stereo16", root, "first.flac")
+	os.Mkdir(filepath.Join(root, "sub"), 0o755)
+
+	l := loadLibrary()
+	t.Cleanup(l.stop)
+	l.open(root)
+	waitForTracks(t, l, "the first scan", func(tracks []engine.Track) bool { return len(tracks) == 1 })
+	l.takeChanged()
+
+	// Files added at the top and in a subfolder are picked up by a rescan
+	added := copyFixture(t, "mono8", root, "added.flac")
+	nested := copyFixture(t, "mono8", root, filepath.Join("sub", "nested.flac"))
+	waitForTracks(t, l, "the added files", func(tracks []engine.Track) bool {
+		return hasTrack(tracks, added) && hasTrack(tracks, nested)
+	})
+	if !l.takeChanged() {
+		t.Error("adding files didn't report a change")
+	}
+
+	// and removed ones are forgotten
+	if err := os.Remove(added); err != nil {
+		t.Fatal(err)
+	}
+	waitForTracks(t, l, "the removed file", func(tracks []engine.Track) bool { return !hasTrack(tracks, added) })
+	if !l.takeChanged() {
+		t.Error("removing a file didn't report a change")
+	}
+}
+
+func TestLibraryScanGivesUpWhenFolderChanges(t *testing.T) {
+	tempSettings(t)
+	root := t.TempDir()
+	copyFixture(t, "stereo16", root, "first.flac")
+
+	// A scan from before the library moved on doesn't touch the index
+	l := &library{entries: map[string]libraryEntry{}, generation: 2}
+	if l.scanOnce(root, 1) {
+		t.Error("an old scan carried on")
+	}
+	if len(l.entries) != 0 || l.version != 0 {
+		t.Errorf("an old scan indexed %d files", len(l.entries))
+	}
+
+	// Opening another folder stops the first one's scan and watcher
+	other := t.TempDir()
+	second := copyFixture(t, "mono8", other, "second.flac")
+	l = loadLibrary()
+	t.Cleanup(l.stop)
+	l.open(root)
+	l.open(other)
+	tracks := waitForTracks(t, l, "the second folder", func(tracks []engine.Track) bool { return len(tracks) == 1 })
+	if tracks[0].Path != second {
+		t.Errorf("got %s, want only %s", tracks[0].Path, second)
+	}
+	late := copyFixture(t, "mono8", root, "late.flac")
+	time.Sleep(rescanDelay + 200*time.Millisecond)
+	if all, _ := l.all(); hasTrack(all, late) {
+		t.Error("the first folder is still watched")
+	}
+}
+
+func TestLibraryKeepsOtherFolders(t *testing.T) {
+	tempSettings(t)
+	root, other := t.TempDir(), t.TempDir()
+	open := copyFixture(t, "stereo16", root, "open.flac")
+	kept := copyFixture(t, "mono8", other, "kept.flac")
+	gone := filepath.Join(other, "gone.flac")
+	l := &library{entries: map[string]libraryEntry{
+		kept: {Path: kept, Title: "Measured", Gain: engine.ReplayGain{TrackGain: -3, HasTrack: true}},
+		gone: {Path: gone},
+	}}
+	if err := l.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening a folder lists only its own tracks, but the rest of the index
+	// stays, gains and all, so going back to the other folder needn't start over
+	l = loadLibrary()
+	t.Cleanup(l.stop)
+	l.open(root)
+	tracks := waitForTracks(t, l, "the open folder", func(tracks []engine.Track) bool { return len(tracks) == 1 })
+	if tracks[0].Path != open {
+		t.Errorf("listed %s, want only %s", tracks[0].Path, open)
+	}
+	all, _ := l.all()
+	if len(all) != 2 || !hasTrack(all, kept) || l.entries[kept].Gain.TrackGain != -3 {
+		t.Errorf("the index has %d tracks and %+v for the other folder, want it kept as it was", len(all), l.entries[kept])
+	}
+	if hasTrack(all, gone) {
+		t.Error("a file that's gone is still in the index")
+	}
+}
+
+func TestLibrarySetGain(t *testing.T) {
+	tagged := engine.ReplayGain{TrackGain: 2, TrackPeak: 0.5, HasTrack: true}
+	l := &library{entries: map[string]libraryEntry{
+		"a.flac": {Path: "a.flac", Gain: engine.ReplayGain{AlbumGain: -1, HasAlbum: true}},
+		"b.flac": {Path: "b.flac", Gain: tagged},
+	}}
+	measured := engine.ReplayGain{TrackGain: -7, TrackPeak: 0.9, HasTrack: true}
+	l.setGain("a.flac", measured)
+	l.setGain("b.flac", measured)
+	l.setGain("unknown.flac", measured)
+
+	want := engine.ReplayGain{TrackGain: -7, TrackPeak: 0.9, HasTrack: true, AlbumGain: -1, HasAlbum: true}
+	if got := l.entries["a.flac"].Gain; got != want {
+		t.Errorf("got %+v, want %+v with the album gain kept", got, want)
+	}
+	if got := l.entries["b.flac"].Gain; got != tagged {
+		t.Errorf("a measurement replaced the tags' gain: %+v", got)
+	}
+	if len(l.entries) != 2 {
+		t.Error("a gain for a file not in the index was kept")
+	}
+}
@@ -4,288 +4,570 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"image"
-	"image/color"
 	"image/draw"
-	"io"
+	_ "image/jpeg"
+	_ "image/png"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
-	"golang.org/x/image/font"
-	"golang.org/x/image/font/opentype"
-
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/audio"
-	"github.com/hajimehoshi/ebiten/v2/audio/mp3"
 	"github.com/hajimehoshi/ebiten/v2/inpututil"
 	"github.com/hajimehoshi/ebiten/v2/text"
 	"github.com/sqweek/dialog"
+
+	"music/engine"
+	"music/remote"
 )
 
 // Constants
 const (
-	screenWidth  = 640
+	screenWidth  = 640 // starting window size; the window can be resized
 	screenHeight = 480
 	sampleRate   = 48000
 	volumeStep   = 0.1
 	buttonWidth  = 150
 	buttonHeight = 30
+	seekStep     = 5 * time.Second
 )
 
-// Colors
-var (
-	backgroundColor  = color.RGBA{0x1A, 0x1A, 0x1A, 0xFF}
-	highlightColor   = color.RGBA{0x00, 0x88, 0xFF, 0xFF}
-	volumeBarColor   = color.RGBA{200, 200, 200, 255}
-	playerBarColor   = color.RGBA{200, 200, 200, 255}
-	progressColor    = color.RGBA{100, 200, 200, 255}
-	playButtonColor  = color.RGBA{0, 0, 255, 255}
-	pauseButtonColor = color.RGBA{255, 0, 0, 255}
-	textColor        = color.RGBA{255, 255, 255, 255}
-	changeDirColor   = color.RGBA{0, 128, 0, 255}
-)
+// Player is the ebiten front-end; playback itself is done by the engine
+type Player struct {
+	engine           *engine.Engine
+	artwork          *ebiten.Image // cover art of the current track, if it has any
+	currentTrack     int           // copies of the engine's list, refreshed by its events
+	tracks           []engine.Track
+	volumeFeedback   string
+	currentDirectory string
+	playlistPath     string // playlist the tracks came from, empty when playing a folder
+	selection        string // what the tracks are when picked in the browser or added by hand
+	settings         Settings
+	ui               layout
+	visualizer       *visualizer
+	library          *library
+	libraryRefreshed time.Time
+
+	// Playlist name being typed after pressing S
+	naming    bool
+	nameInput []rune
+	runes     []rune
+
+	// Track list scrolling and filtering
+	filter        []rune
+	filterFocused bool
+	selected      int // highlighted row among the filter's matches
+	listSelected  int // track Ctrl+clicked for [ ] to move, -1 for none
+	listScroll    int // first visible row
+	followedTrack int // current track the list last scrolled to
+
+	// Play queue panel
+	queue         []engine.Track // copy of the engine's queue
+	nowPlaying    engine.Track   // the track playing, which may have come from the queue
+	hasTrack      bool
+	fromQueue     bool
+	queueSelected int // highlighted queued track, -1 for none
+	queueScroll   int
+	dragging      int // queued track being dragged to a new place, -1 for none
+
+	// Library browser, shown in place of the track list
+	browsing bool
+	browser  browser
+
+	status      string
+	statusUntil time.Time
+}
 
-// Load your custom font
-var myFont font.Face
+// Game struct
+type Game struct {
+	player *Player
+}
 
-// Replace the loadFont function with this:
-func loadFont() error {
-	// Google Font URL via jsDelivr CDN
-	fontURL := "https://raw.githubusercontent.com/NobleSkye/fonts/main/Poppins-SemiBold.ttf"
+// Keep the UI in step with the engine
+func (p *Player) handleEvent(event engine.Event) {
+	switch event.Kind {
+	case engine.TrackChanged, engine.ListChanged:
+		p.tracks = p.engine.Tracks()
+		p.currentTrack = p.engine.Current()
+		p.nowPlaying, p.hasTrack = p.engine.NowPlaying()
+		p.fromQueue = p.engine.FromQueue()
+		if p.listSelected >= len(p.tracks) {
+			p.listSelected = -1
+		}
+		if event.Kind == engine.TrackChanged {
+			p.updateArtwork()
+			// Save a new track in case the player doesn't get to exit cleanly;
+			// one starting over, e.g. on repeat, is nothing new to save
+			if p.listTrack() != p.settings.Track || p.sourcePath() != p.settings.Source {
+				p.savePlayback()
+			}
+		}
+	case engine.QueueChanged:
+		p.queue = p.engine.Queue()
+		if p.queueSelected >= len(p.queue) {
+			p.queueSelected = len(p.queue) - 1
+		}
+	case engine.GainMeasured:
+		p.library.setGain(event.Path, event.Gain)
+	case engine.Error:
+		fmt.Println("Error playing track:", event.Err)
+	}
+}
 
-	// Make HTTP request to get font
-	resp, err := http.Get(fontURL)
-	if err != nil {
-		return fmt.Errorf("error downloading font: %w", err)
+// Show the current track's cover art
+func (p *Player) updateArtwork() {
+	if p.artwork != nil {
+		p.artwork.Deallocate()
+		p.artwork = nil
 	}
-	defer resp.Body.Close()
+	if p.hasTrack {
+		p.artwork = loadArtwork(p.nowPlaying.Path)
+	}
+}
 
-	// Read font data
-	fontBytes, err := io.ReadAll(resp.Body)
+// Decode a track's embedded cover art, or nil if it has none
+func loadArtwork(path string) *ebiten.Image {
+	tags, err := readTags(path, true)
+	if err != nil || tags.Artwork == nil {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(tags.Artwork))
 	if err != nil {
-		return fmt.Errorf("error reading font data: %w", err)
+		return nil
+	}
+	return ebiten.NewImageFromImage(img)
+}
+
+// Track for a file, with whatever its tags say about it
+func newTrack(path string) engine.Track {
+	track := engine.Track{Name: filepath.Base(path), Path: path}
+	if tags, err := readTags(path, false); err == nil {
+		track.Title = tags.Title
+		track.Artist = tags.Artist
+		track.Album = tags.Album
+		track.TrackNumber = tags.TrackNumber
+		track.Genre = tags.Genre
+		track.Gain = tags.Gain
 	}
+	return track
+}
 
-	// Parse font
-	fnt, err := opentype.Parse(fontBytes)
-	if err != nil {
-		return fmt.Errorf("error parsing font: %w", err)
+// NewPlayer initializes a Player with the tracks in a directory or playlist
+func NewPlayer(eng *engine.Engine, source string, settings Settings) (*Player, error) {
+	p := &Player{
+		engine:     eng,
+		settings:   settings,
+		visualizer: newVisualizer(),
+		library:    loadLibrary(),
+
+		listSelected:  -1,
+		queueSelected: -1,
+		dragging:      -1,
 	}
+	p.ui = p.newLayout()
+	p.ui.arrange(screenWidth, screenHeight)
+	eng.Subscribe(p.handleEvent)
 
-	// Create font face
-	myFont, err = opentype.NewFace(fnt, &opentype.FaceOptions{
-		Size:    12,
-		DPI:     72,
-		Hinting: font.HintingFull,
-	})
-	if err != nil {
-		return fmt.Errorf("error creating font face: %w", err)
+	if err := p.openSource(source); err != nil {
+		return nil, err
+	}
+	if p.sourcePath() == settings.Source {
+		p.resume()
 	}
 
-	return nil
+	return p, nil
 }
 
-// Track information
-type Track struct {
-	name     string
-	data     []byte
-	duration time.Duration
+// The folder or playlist the tracks came from
+func (p *Player) sourcePath() string {
+	if p.playlistPath != "" {
+		return p.playlistPath
+	}
+	return p.currentDirectory
 }
 
-// Player struct
-type Player struct {
-	audioContext     *audio.Context
-	audioPlayer      *audio.Player
-	currentTrack     int
-	tracks           []Track
-	volume           float64
-	volumeFeedback   string
-	currentDirectory string
+// Carry on from the track and position saved last time, if it's still in the list
+func (p *Player) resume() {
+	for i, track := range p.engine.Tracks() {
+		if track.Path == p.settings.Track {
+			if err := p.engine.PlayTrack(i); err == nil {
+				p.seek(time.Duration(p.settings.Position * float64(time.Second)))
+			}
+			return
+		}
+	}
 }
 
-// Game struct
-type Game struct {
-	player *Player
+// Path of the list's current track, empty when the list is. A queued track
+// isn't in the list, so the list's place is remembered instead of it.
+func (p *Player) listTrack() string {
+	if p.currentTrack < len(p.tracks) {
+		return p.tracks[p.currentTrack].Path
+	}
+	return ""
 }
 
-// Load track data
-func (p *Player) loadTrackData() error {
-	track := p.tracks[p.currentTrack]
-
-	reader, err := mp3.DecodeF32(bytes.NewReader(track.data))
-	if err != nil {
-		return err
+// Remember where playback is and how it's set up for the next run
+func (p *Player) savePlayback() {
+	p.settings.Source = p.sourcePath()
+	p.settings.Track = p.listTrack()
+	p.settings.Position = 0
+	if !p.fromQueue {
+		p.settings.Position = p.engine.Position().Seconds()
 	}
+	p.settings.Volume = p.engine.Volume()
+	p.settings.Shuffle = p.engine.Shuffle()
+	p.settings.Repeat = repeatModeSettings[p.engine.Repeat()]
+	if err := p.settings.save(); err != nil {
+		fmt.Println("Error saving settings:", err)
+	}
+}
 
-	player, err := p.audioContext.NewPlayerF32(reader)
+// Replace the track list with a directory's tracks or a playlist's entries
+// A folder's tracks come from the library, which keeps scanning it in the
+// background and picks up files being added or removed.
+func (p *Player) openSource(source string) error {
+	absPath, err := filepath.Abs(source)
 	if err != nil {
-		return err
+		absPath = source
 	}
 
-	if p.audioPlayer != nil {
-		p.audioPlayer.Close()
+	var tracks []engine.Track
+	if isPlaylistFile(source) {
+		if tracks, err = readPlaylist(source); err != nil {
+			return err
+		}
+		p.library.stop()
+		p.playlistPath = absPath
+		p.currentDirectory = filepath.Dir(absPath)
+	} else {
+		info, err := os.Stat(source)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a folder or playlist", source)
+		}
+		tracks = p.library.open(absPath)
+		p.playlistPath = ""
+		p.currentDirectory = absPath
 	}
+	p.selection = ""
+	p.tracks = tracks
+	p.currentTrack = 0
+	p.listSelected = -1
+	return p.engine.Load(tracks)
+}
 
-	p.audioPlayer = player
-	p.tracks[p.currentTrack].duration = time.Duration(reader.Length()) * time.Second / 8 / sampleRate
-	p.audioPlayer.SetVolume(p.volume)
-	p.audioPlayer.Play()
+// Pick up tracks the library scan has found, at most twice a second so a big
+// scan doesn't keep rebuilding the list
+func (p *Player) refreshLibrary() {
+	if p.playlistPath != "" || p.selection != "" || time.Since(p.libraryRefreshed) < 500*time.Millisecond {
+		return
+	}
+	p.libraryRefreshed = time.Now()
+	if p.library.takeChanged() {
+		if err := p.engine.Replace(p.library.tracks()); err != nil {
+			fmt.Println("Error playing track:", err)
+		}
+	}
+}
 
-	return nil
+// Show a short message under the progress bar
+func (p *Player) setStatus(message string) {
+	p.status = message
+	p.statusUntil = time.Now().Add(3 * time.Second)
 }
 
-// Initialize tracks list by scanning a directory
-func initTracks(directory string) ([]Track, error) {
-	var tracks []Track
+// Start an empty, unsaved playlist
+func (p *Player) newPlaylist() {
+	p.engine.Load(nil)
+	p.tracks = nil
+	p.currentTrack = 0
+	p.playlistPath = ""
+	p.selection = "New playlist"
+	p.setStatus("New playlist: A to add tracks, S to save")
+}
 
-	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// Extensions the decoders play, without their dots, for file dialogs
+func audioExtensions() []string {
+	var extensions []string
+	for _, d := range decoders {
+		for _, ext := range d.extensions {
+			extensions = append(extensions, ext[1:])
 		}
-		if !info.IsDir() && filepath.Ext(info.Name()) == ".mp3" {
-			data, err := os.ReadFile(path)
-			if err != nil {
-				return err
-			}
-			tracks = append(tracks, Track{name: info.Name(), data: data, duration: 0})
-		}
-		return nil
-	})
-	return tracks, err
+	}
+	return extensions
 }
 
-// NewPlayer initializes a Player
-func NewPlayer(audioContext *audio.Context, directory string) (*Player, error) {
-	tracks, err := initTracks(directory)
-	if err != nil {
-		return nil, err
+// Pick an audio file and add it to the end of the playlist
+func (p *Player) addTrack() error {
+	file, err := dialog.File().Title("Add Track").Filter("Audio files", audioExtensions()...).Load()
+	if err != nil || file == "" {
+		return nil
 	}
+	return p.engine.Add(newTrack(file))
+}
 
-	absPath, err := filepath.Abs(directory)
-	if err != nil {
-		absPath = directory
+// Choose what to play: a playlist opens as it is, and a track opens the
+// folder it's in. The dialog can't pick folders themselves, which is why it's
+// a track that stands for its folder.
+func (p *Player) changeDirectory() {
+	var playlists []string
+	for _, ext := range playlistExtensions {
+		playlists = append(playlists, ext[1:])
+	}
+	file, err := dialog.File().Title("Choose Music Directory or Playlist").
+		Filter("Playlists", playlists...).
+		Filter("Audio files", audioExtensions()...).
+		Load()
+	if err != nil || file == "" {
+		return
+	}
+	source := file
+	if !isPlaylistFile(file) {
+		source = filepath.Dir(file)
+	}
+	if err := p.openSource(source); err != nil {
+		fmt.Println("Error loading tracks:", err)
 	}
+}
 
-	p := &Player{
-		audioContext:     audioContext,
-		currentTrack:     0,
-		tracks:           tracks,
-		volume:           1.0,
-		currentDirectory: absPath,
+// Save the track list under a name, as PLS for a .pls name and M3U8 otherwise.
+// Bare names are saved in the current folder.
+func (p *Player) savePlaylist(name string) error {
+	if !isPlaylistFile(name) {
+		name += ".m3u8"
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.currentDirectory, name)
 	}
+	if err := writePlaylist(path, p.engine.Tracks()); err != nil {
+		return err
+	}
+	p.playlistPath = path
+	p.setStatus("Saved " + filepath.Base(path))
+	return nil
+}
 
-	if len(tracks) > 0 {
-		if err := p.loadTrackData(); err != nil {
-			return nil, err
+// Type the name to save the playlist as; Enter saves and Escape cancels
+func (p *Player) updateNaming() {
+	p.runes = ebiten.AppendInputChars(p.runes[:0])
+	p.nameInput = append(p.nameInput, p.runes...)
+	if inpututil.IsKeyJustPressed(ebiten.KeyBackspace) && len(p.nameInput) > 0 {
+		p.nameInput = p.nameInput[:len(p.nameInput)-1]
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		p.naming = false
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter) {
+		p.naming = false
+		if name := strings.TrimSpace(string(p.nameInput)); name != "" {
+			if err := p.savePlaylist(name); err != nil {
+				p.setStatus("Error saving playlist: " + err.Error())
+			}
 		}
 	}
-
-	return p, nil
 }
 
-// Reload tracks from a new directory
-func (p *Player) reloadTracks(directory string) error {
-	tracks, err := initTracks(directory)
-	if err != nil {
-		return err
-	}
-	p.tracks = tracks
-	p.currentTrack = 0
-	p.currentDirectory = directory
-	if len(tracks) > 0 {
-		return p.loadTrackData()
+// Apply changed font and theme settings and remember them for next time
+func (p *Player) applySettings() {
+	applyFont(p.settings)
+	theme = themeByName(p.settings.Theme)
+	if err := p.settings.save(); err != nil {
+		fmt.Println("Error saving settings:", err)
 	}
-	return nil
 }
 
-// Toggle play/pause state
-func (p *Player) togglePlayPause() {
-	if p.audioPlayer.IsPlaying() {
-		p.audioPlayer.Pause()
-	} else {
-		p.audioPlayer.Play()
+// Jump to a position in the current track
+func (p *Player) seek(position time.Duration) {
+	if err := p.engine.Seek(position); err != nil {
+		fmt.Println("Error seeking:", err)
 	}
 }
 
 // Update player state with playlist navigation
 func (p *Player) update() error {
+	if p.status != "" && time.Now().After(p.statusUntil) {
+		p.status = ""
+	}
+	p.refreshLibrary()
+
+	// While a playlist name is being typed the keyboard belongs to it
+	if p.naming {
+		p.updateNaming()
+		return nil
+	}
+	if p.filterFocused {
+		return p.updateFilter()
+	}
+	p.updateQueue()
+	if p.browsing {
+		return p.updateBrowser()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyB) {
+		p.toggleBrowser()
+		return nil
+	}
+
+	if err := p.updateTrackList(); err != nil {
+		return err
+	}
+
 	// Volume controls
 	if inpututil.IsKeyJustPressed(ebiten.KeyUp) {
-		p.volume = min(p.volume+volumeStep, 1.0)
-		if p.audioPlayer != nil {
-			p.audioPlayer.SetVolume(p.volume)
-		}
-		p.volumeFeedback = fmt.Sprintf("Volume: %.0f%%", p.volume*100)
+		p.engine.SetVolume(p.engine.Volume() + volumeStep)
+		p.volumeFeedback = fmt.Sprintf("Volume: %.0f%%", p.engine.Volume()*100)
 	}
 	if inpututil.IsKeyJustPressed(ebiten.KeyDown) {
-		p.volume = max(p.volume-volumeStep, 0.0)
-		if p.audioPlayer != nil {
-			p.audioPlayer.SetVolume(p.volume)
-		}
-		p.volumeFeedback = fmt.Sprintf("Volume: %.0f%%", p.volume*100)
+		p.engine.SetVolume(p.engine.Volume() - volumeStep)
+		p.volumeFeedback = fmt.Sprintf("Volume: %.0f%%", p.engine.Volume()*100)
 	}
 
 	// Track navigation
-	if inpututil.IsKeyJustPressed(ebiten.KeyRight) && len(p.tracks) > 0 {
-		p.currentTrack = (p.currentTrack + 1) % len(p.tracks)
-		if err := p.loadTrackData(); err != nil {
+	if inpututil.IsKeyJustPressed(ebiten.KeyRight) {
+		if err := p.engine.Next(); err != nil {
 			return err
 		}
 	}
-	if inpututil.IsKeyJustPressed(ebiten.KeyLeft) && len(p.tracks) > 0 {
-		p.currentTrack = (p.currentTrack - 1 + len(p.tracks)) % len(p.tracks)
-		if err := p.loadTrackData(); err != nil {
+	if inpututil.IsKeyJustPressed(ebiten.KeyLeft) {
+		if err := p.engine.Previous(); err != nil {
 			return err
 		}
 	}
 
+	// Shuffle and repeat modes
+	if inpututil.IsKeyJustPressed(ebiten.KeyH) {
+		p.engine.SetShuffle(!p.engine.Shuffle())
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		p.engine.SetRepeat(p.engine.Repeat().Next())
+	}
+
 	// Playback controls via space
 	if inpututil.IsKeyJustPressed(ebiten.KeySpace) {
-		p.togglePlayPause()
+		p.engine.TogglePlay()
 	}
 
-	// Handle mouse clicks for changing directory
-	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
-		mouseX, mouseY := ebiten.CursorPosition()
-
-		// Button bounds for directory change
-		buttonX := 500
-		buttonY := 450
-		if buttonX <= mouseX && mouseX <= buttonX+buttonWidth &&
-			buttonY <= mouseY && mouseY <= buttonY+buttonHeight {
-			newDirectory, err := dialog.Directory().Title("Choose Music Directory").Browse()
-			if err == nil && newDirectory != "" {
-				if err := p.reloadTracks(newDirectory); err != nil {
-					fmt.Println("Error loading tracks:", err)
-				}
-			}
+	// Seeking with , and . or Home to restart
+	if p.hasTrack {
+		if inpututil.IsKeyJustPressed(ebiten.KeyComma) {
+			p.seek(p.engine.Position() - seekStep)
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyPeriod) {
+			p.seek(p.engine.Position() + seekStep)
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyHome) {
+			p.seek(0)
 		}
+	}
 
-		// Play button bounds
-		playButtonX := 20
-		playButtonY := 400
-		if playButtonX <= mouseX && mouseX <= playButtonX+40 &&
-			playButtonY <= mouseY && mouseY <= playButtonY+30 {
-			p.togglePlayPause()
+	// Click on the progress bar to seek
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && p.hasTrack {
+		mouseX, mouseY := ebiten.CursorPosition()
+		if bar := p.ui.progressBar; image.Pt(mouseX, mouseY).In(bar) {
+			fraction := float64(mouseX-bar.Min.X) / float64(bar.Dx())
+			p.seek(time.Duration(fraction * float64(p.engine.Duration())))
 		}
+	}
 
-		// Pause button bounds
-		pauseButtonX := playButtonX + 50
-		if pauseButtonX <= mouseX && mouseX <= pauseButtonX+40 &&
-			playButtonY <= mouseY && mouseY <= playButtonY+30 {
-			p.togglePlayPause()
+	// Accessibility: font, font size and color theme
+	if inpututil.IsKeyJustPressed(ebiten.KeyF) {
+		p.settings.Font = nextFont(p.settings.font())
+		p.settings.FontFlag = ""
+		p.applySettings()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEqual) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadAdd) {
+		p.settings.FontSize = min(p.settings.FontSize+fontSizeStep, maxFontSize)
+		p.applySettings()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyMinus) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadSubtract) {
+		p.settings.FontSize = max(p.settings.FontSize-fontSizeStep, minFontSize)
+		p.applySettings()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyT) {
+		p.settings.Theme = nextTheme(p.settings.Theme).Name
+		p.applySettings()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyC) {
+		p.settings.Crossfade = nextCrossfade(p.settings.Crossfade)
+		p.engine.SetCrossfade(p.settings.crossfadeDuration())
+		p.applySettings()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		preset := nextEQPreset(p.settings.EQPreset)
+		p.settings.EQPreset = preset.Name
+		p.settings.EQ = preset.Gains[:]
+		p.engine.SetEQ(preset.Gains)
+		p.applySettings()
+		p.setStatus("EQ: " + preset.Name)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyG) {
+		mode := p.engine.GainMode().Next()
+		p.settings.ReplayGain = gainModeSettings[mode]
+		p.engine.SetGainMode(mode)
+		p.applySettings()
+		p.setStatus(mode.String())
+	}
+
+	// Playlist editing; Delete and [ ] act on the queued track selected, if any,
+	// and [ ] then on the track selected in the list
+	if inpututil.IsKeyJustPressed(ebiten.KeyA) {
+		if err := p.addTrack(); err != nil {
+			return err
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyDelete) {
+		if p.queueSelected >= 0 {
+			p.engine.RemoveQueued(p.queueSelected)
+		} else if err := p.engine.RemoveCurrent(); err != nil {
+			return err
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketLeft) {
+		if p.queueSelected >= 0 {
+			p.moveQueued(-1)
+		} else if p.listSelected >= 0 {
+			p.moveSelected(-1)
+		} else {
+			p.engine.MoveCurrent(-1)
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketRight) {
+		if p.queueSelected >= 0 {
+			p.moveQueued(1)
+		} else if p.listSelected >= 0 {
+			p.moveSelected(1)
+		} else {
+			p.engine.MoveCurrent(1)
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyX) && len(p.queue) > 0 {
+		p.engine.ClearQueue()
+		p.setStatus("Queue cleared")
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyN) {
+		p.newPlaylist()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyS) {
+		p.naming = true
+		p.nameInput = p.nameInput[:0]
+		if p.playlistPath != "" {
+			p.nameInput = append(p.nameInput, []rune(filepath.Base(p.playlistPath))...)
 		}
+		// The S itself arrives as a typed character this frame; drop it
+		p.runes = ebiten.AppendInputChars(p.runes[:0])
 	}
 
-	// Auto-advance
-	if p.audioPlayer != nil && !p.audioPlayer.IsPlaying() &&
-		len(p.tracks) > 0 &&
-		p.audioPlayer.Current() >= p.tracks[p.currentTrack].duration {
-		p.currentTrack = (p.currentTrack + 1) % len(p.tracks)
-		return p.loadTrackData()
+	// Buttons
+	if err := p.clickButtons(); err != nil {
+		return err
 	}
 
 	// Clear volume feedback after a short time
@@ -301,87 +583,129 @@ func (p *Player) update() error {
 
 // Draw the UI with playlist and buttons
 func (p *Player) draw(screen *ebiten.Image) {
-	screen.Fill(backgroundColor)
+	screen.Fill(theme.Background)
 
 	face := myFont
 
-	// Draw current directory at the top
-	text.Draw(screen, "Current Directory: "+p.currentDirectory, face, 10, 15, textColor)
-	text.Draw(screen, "Up & Down For Volume"+p.volumeFeedback, face, 220, 360, textColor)
-	text.Draw(screen, "Space Unpause/Pause"+p.volumeFeedback, face, 220, 380, textColor)
+	// Draw current directory or playlist at the top
+	if p.playlistPath != "" {
+		text.Draw(screen, "Playlist: "+p.playlistPath, face, 10, 15, theme.Text)
+	} else if p.selection != "" {
+		text.Draw(screen, "Playing: "+p.selection, face, 10, 15, theme.Text)
+	} else {
+		text.Draw(screen, "Current Directory: "+p.currentDirectory, face, 10, 15, theme.Text)
+	}
+	for i, hint := range keyHints {
+		text.Draw(screen, hint, face, p.ui.hints.X, p.ui.hints.Y+i*rowHeight(), theme.Text)
+	}
+
+	// Playlist name box, or a status message
+	if p.naming {
+		text.Draw(screen, "Save playlist as: "+string(p.nameInput)+"_", face, p.ui.status.X, p.ui.status.Y, theme.Highlight)
+	} else if p.status != "" {
+		text.Draw(screen, p.status, face, p.ui.status.X, p.ui.status.Y, theme.Text)
+	} else if scanning, done, found := p.library.progress(); scanning {
+		progress := "Scanning library..."
+		if found > 0 {
+			progress = fmt.Sprintf("Scanning library: %d of %d files", done, found)
+		}
+		text.Draw(screen, progress, face, p.ui.status.X, p.ui.status.Y, theme.Text)
+	}
 
-	if len(p.tracks) > 0 {
-		text.Draw(screen, p.tracks[p.currentTrack].name, face, 20, 35, textColor)
+	if p.hasTrack {
+		track := p.nowPlaying
+		nowPlaying := track.DisplayName()
+		if track.Album != "" {
+			nowPlaying += " (" + track.Album + ")"
+		}
+		if p.fromQueue {
+			nowPlaying += "  [queued]"
+		}
+		text.Draw(screen, nowPlaying, face, 20, 35, theme.Text)
 
 		// Draw progress bar
-		currentTime := p.audioPlayer.Current()
-		progress := float64(currentTime) / float64(p.tracks[p.currentTrack].duration)
+		currentTime := p.engine.Position()
+		progress := 0.0
+		if duration := track.Duration; duration > 0 {
+			progress = float64(currentTime) / float64(duration)
+		}
 		if progress > 1 {
 			progress = 1
 		}
 
-		x, y := 10, 50
-		w, h := screenWidth-20, 10
-		draw.Draw(screen, image.Rect(x, y, x+w, y+h), &image.Uniform{C: playerBarColor}, image.Point{}, draw.Src)
+		x, y := p.ui.progressBar.Min.X, p.ui.progressBar.Min.Y
+		w, h := p.ui.progressBar.Dx(), p.ui.progressBar.Dy()
+		draw.Draw(screen, image.Rect(x, y, x+w, y+h), &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
 		progressWidth := int(float64(w) * progress)
-		draw.Draw(screen, image.Rect(x, y, x+progressWidth, y+h), &image.Uniform{C: progressColor}, image.Point{}, draw.Src)
+		draw.Draw(screen, image.Rect(x, y, x+progressWidth, y+h), &image.Uniform{C: theme.Progress}, image.Point{}, draw.Src)
 
 		// Draw times
 		currentTimeStr := formatDuration(currentTime)
-		totalTimeStr := formatDuration(p.tracks[p.currentTrack].duration)
-		text.Draw(screen, currentTimeStr, face, x, y+h+15, textColor)
-		text.Draw(screen, totalTimeStr, face, x+w-50, y+h+15, textColor)
+		totalTimeStr := formatDuration(track.Duration)
+		text.Draw(screen, currentTimeStr, face, x, y+h+15, theme.Text)
+		text.Draw(screen, totalTimeStr, face, x+w-50, y+h+15, theme.Text)
 	} else {
-		text.Draw(screen, "No tracks loaded", face, 20, 35, textColor)
+		text.Draw(screen, "No tracks loaded", face, 20, 35, theme.Text)
 	}
 
-	// Draw track list
-	for i, track := range p.tracks {
-		var color color.Color
-		if i == p.currentTrack {
-			color = highlightColor
-		} else {
-			color = textColor
-		}
-		xPos := 100
-		if (i+1)%2 == 0 {
-			xPos = 350
-		}
-		yPos := 100 + (i/2)*20
-		text.Draw(screen, track.name, face, xPos, yPos, color)
+	p.visualizer.drawSpectrum(screen, p.ui.spectrum)
+	// Sound settings over the corner of the spectrum
+	sound := "EQ: " + p.settings.EQPreset
+	if mode := p.engine.GainMode(); mode != engine.GainOff {
+		sound += ", " + mode.String()
 	}
+	text.Draw(screen, sound, face, p.ui.spectrum.Min.X+4, p.ui.spectrum.Min.Y+lineHeight(), theme.Text)
+	p.visualizer.drawWaveform(screen, p.ui.waveform)
+
+	// Draw track list, or the library browser in its place, and the queue
+	// beside it; each is clipped so long names don't run into the other
+	list := screen.SubImage(p.ui.trackList).(*ebiten.Image)
+	if p.browsing {
+		p.drawBrowser(list)
+	} else {
+		p.drawTrackList(list)
+	}
+	p.drawQueue(screen.SubImage(p.ui.queue).(*ebiten.Image))
 
 	// Draw volume bar
-	volumeX, volumeY := 10, 400
-	volumeW, volumeH := 10, screenHeight-420
-	draw.Draw(screen, image.Rect(volumeX, volumeY, volumeX+volumeW, volumeY+volumeH), &image.Uniform{C: volumeBarColor}, image.Point{}, draw.Src)
-	volumeProgress := int(float64(volumeH) * (1 - p.volume))
-	draw.Draw(screen, image.Rect(volumeX, volumeY+volumeProgress, volumeX+volumeW, volumeY+volumeH), &image.Uniform{C: progressColor}, image.Point{}, draw.Src)
+	bar := p.ui.volumeBar
+	draw.Draw(screen, bar, &image.Uniform{C: theme.VolumeBar}, image.Point{}, draw.Src)
+	volume := p.engine.Volume()
+	volumeProgress := int(float64(bar.Dy()) * (1 - volume))
+	draw.Draw(screen, image.Rect(bar.Min.X, bar.Min.Y+volumeProgress, bar.Max.X, bar.Max.Y), &image.Uniform{C: theme.Progress}, image.Point{}, draw.Src)
 
 	// Draw volume text
-	volumeStr := fmt.Sprintf("Volume: %.0f%%", p.volume*100)
-	text.Draw(screen, volumeStr, face, volumeX+15, volumeY+volumeH-15, textColor)
-
-	// Draw play button
-	playButtonY := 400
-	playButtonX := volumeX + volumeW + 10
-	draw.Draw(screen, image.Rect(playButtonX, playButtonY, playButtonX+40, playButtonY+30), &image.Uniform{C: playButtonColor}, image.Point{}, draw.Src)
-	text.Draw(screen, ">", face, playButtonX+5, playButtonY+20, textColor)
-
-	// Draw pause button
-	pauseButtonX := playButtonX + 50
-	draw.Draw(screen, image.Rect(pauseButtonX, playButtonY, pauseButtonX+40, playButtonY+30), &image.Uniform{C: pauseButtonColor}, image.Point{}, draw.Src)
-	text.Draw(screen, "||", face, pauseButtonX+5, playButtonY+20, textColor)
-
-	// Draw directory change button
-	buttonX := 500
-	buttonY := 450
-	draw.Draw(screen, image.Rect(buttonX, buttonY, buttonX+buttonWidth, buttonY+buttonHeight), &image.Uniform{C: changeDirColor}, image.Point{}, draw.Src)
-	text.Draw(screen, "Change Directory", face, buttonX+5, buttonY+20, textColor)
+	volumeStr := fmt.Sprintf("Volume: %.0f%%", volume*100)
+	text.Draw(screen, volumeStr, face, p.ui.volume.X, p.ui.volume.Y, theme.Text)
+
+	// Draw buttons
+	for _, b := range p.ui.buttons() {
+		b.draw(screen)
+	}
+
+	// Draw cover art beside the track list
+	if p.artwork != nil {
+		bounds := p.artwork.Bounds()
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(float64(p.ui.artwork.Dx())/float64(bounds.Dx()), float64(p.ui.artwork.Dy())/float64(bounds.Dy()))
+		op.GeoM.Translate(float64(p.ui.artwork.Min.X), float64(p.ui.artwork.Min.Y))
+		op.Filter = ebiten.FilterLinear
+		screen.DrawImage(p.artwork, op)
+	}
+
+	// Draw playback mode below the play buttons
+	mode := p.engine.Repeat().String()
+	if p.engine.Shuffle() {
+		mode = "Shuffle, " + mode
+	}
+	if crossfade := p.engine.Crossfade(); crossfade > 0 {
+		mode += fmt.Sprintf(", %gs crossfade", crossfade.Seconds())
+	}
+	text.Draw(screen, mode, face, p.ui.mode.X, p.ui.mode.Y, theme.Text)
 
 	// Draw volume feedback if available
 	if p.volumeFeedback != "" {
-		text.Draw(screen, p.volumeFeedback, face, 20, 370, textColor)
+		text.Draw(screen, p.volumeFeedback, face, 20, p.ui.volumeBar.Min.Y-30, theme.Text)
 	}
 }
 
@@ -410,6 +734,15 @@ func max(a, b float64) float64 {
 
 // Update method for Game
 func (g *Game) Update() error {
+	if ebiten.IsWindowBeingClosed() {
+		g.player.savePlayback()
+		if err := g.player.library.save(); err != nil {
+			fmt.Println("Error saving library:", err)
+		}
+		return ebiten.Termination
+	}
+	g.player.engine.Update()
+	g.player.visualizer.update(g.player.engine)
 	return g.player.update()
 }
 
@@ -418,26 +751,81 @@ func (g *Game) Draw(screen *ebiten.Image) {
 	g.player.draw(screen)
 }
 
-// Layout method for Game
-func (g *Game) Layout(_, _ int) (int, int) {
-	return screenWidth, screenHeight
+// Layout method for Game; the screen matches the window so the layout can use the space
+func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
+	g.player.ui.arrange(outsideWidth, outsideHeight)
+	return outsideWidth, outsideHeight
 }
 
 func main() {
-	// Load the custom font
-	if err := loadFont(); err != nil {
-		fmt.Println("Error loading font:", err)
-		return
+	fontPath := flag.String("font", "", "path to a .ttf/.otf font to use instead of the bundled one")
+	dir := flag.String("dir", "", "folder or playlist to open instead of the one open last time")
+	remoteAddr := flag.String("remote", "", "address to serve the HTTP remote control on, e.g. localhost:8080, or :8080 for other devices on the network")
+	remoteToken := flag.String("remote-token", "", "token the remote control's requests must carry; a random one is made and printed if not given")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: music [flags] [directory or .m3u/.m3u8/.pls playlist]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// Restore settings and where playback was. A font given on the command
+	// line is used for this run only, so it isn't saved over the chosen one.
+	settings := loadSettings()
+	settings.FontFlag = *fontPath
+
+	// Play the folder or playlist given on the command line, or carry on with
+	// the last one (the bundled mp3 folder the first time)
+	source := settings.Source
+	if flag.NArg() > 0 {
+		source = flag.Arg(0)
+	}
+	if *dir != "" {
+		source = *dir
+	}
+	if _, err := os.Stat(source); err != nil && source == settings.Source {
+		source = "mp3" // the last folder has gone
 	}
+	theme = themeByName(settings.Theme)
+	applyFont(settings)
 
+	// The engine is the one stream the audio device plays; it switches tracks itself
+	eng := engine.New(openTrack, sampleRate)
+	settings.applyTo(eng)
 	audioContext := audio.NewContext(sampleRate)
-	player, err := NewPlayer(audioContext, "mp3")
+	output, err := audioContext.NewPlayerF32(eng)
+	if err != nil {
+		fmt.Println("Error opening audio output:", err)
+		return
+	}
+	output.SetBufferSize(100 * time.Millisecond)
+	eng.SetSink(output)
+
+	player, err := NewPlayer(eng, source, settings)
 	if err != nil {
 		fmt.Println("Error initializing player:", err)
 		return
 	}
 
+	startMPRIS(eng)
+
+	// Scripts and other devices can drive the engine too, when asked for
+	if *remoteAddr != "" {
+		token := *remoteToken
+		if token == "" {
+			token = remote.NewToken()
+		}
+		fmt.Printf("Remote control on %s, send \"Authorization: Bearer %s\" with each request\n", *remoteAddr, token)
+		go func() {
+			if err := http.ListenAndServe(*remoteAddr, remote.Handler(eng, token)); err != nil {
+				fmt.Println("Error running remote control:", err)
+			}
+		}()
+	}
+
 	ebiten.SetWindowSize(screenWidth, screenHeight)
+	ebiten.SetWindowSizeLimits(minWidth, minHeight, -1, -1)
+	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
+	ebiten.SetWindowClosingHandled(true)
 	ebiten.SetWindowTitle("Skye's Music Player")
 
 	if err := ebiten.RunGame(&Game{player}); err != nil {
@@ -0,0 +1,4 @@
+// Package mpris publishes the engine on the D-Bus session bus as an MPRIS2
+// media player, so desktop media keys and panel widgets can see what's playing
+// and control it. It only does anything on Linux.
+package mpris
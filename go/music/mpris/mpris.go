@@ -0,0 +1,411 @@
+//go:build linux
+
+package mpris
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+
+	"music/engine"
+)
+
+const (
+	objectPath  dbus.ObjectPath = "/org/mpris/MediaPlayer2"
+	rootIface                   = "org.mpris.MediaPlayer2"
+	playerIface                 = "org.mpris.MediaPlayer2.Player"
+	propsIface                  = "org.freedesktop.DBus.Properties"
+	busName                     = "org.mpris.MediaPlayer2.skyesmusicplayer"
+
+	noTrack dbus.ObjectPath = "/org/mpris/MediaPlayer2/TrackList/NoTrack"
+)
+
+// MPRIS loop statuses for each repeat mode
+var loopStatus = map[engine.RepeatMode]string{
+	engine.RepeatAll: "Playlist",
+	engine.RepeatOne: "Track",
+	engine.StopAtEnd: "None",
+}
+
+// Server is the engine published on a bus
+type Server struct {
+	conn *dbus.Conn
+	eng  *engine.Engine
+}
+
+// Start connects to the session bus and publishes the engine on it
+func Start(eng *engine.Engine) (*Server, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	s, err := Export(conn, eng)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Export publishes the engine on a connection, e.g. to a private bus, and
+// claims a player name. Property changes are signalled as the engine's events
+// are delivered from its Update.
+func Export(conn *dbus.Conn, eng *engine.Engine) (*Server, error) {
+	s := &Server{conn: conn, eng: eng}
+	exports := []struct {
+		v       any
+		mapping map[string]string // Go method names that differ from the D-Bus ones
+		iface   string
+	}{
+		{root{s}, nil, rootIface},
+		{player{s}, map[string]string{"SeekBy": "Seek"}, playerIface},
+		{properties{s}, nil, propsIface},
+		{introspect.Introspectable(strings.TrimSpace(introspect.IntrospectDeclarationString) + introspection), nil, "org.freedesktop.DBus.Introspectable"},
+	}
+	for _, e := range exports {
+		if err := conn.ExportWithMap(e.v, e.mapping, objectPath, e.iface); err != nil {
+			return nil, err
+		}
+	}
+
+	// A second copy of the player takes a name of its own, as MPRIS suggests
+	reply, err := conn.RequestName(busName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		name := fmt.Sprintf("%s.instance%d", busName, os.Getpid())
+		if _, err := conn.RequestName(name, dbus.NameFlagDoNotQueue); err != nil {
+			return nil, err
+		}
+	}
+
+	eng.Subscribe(s.handleEvent)
+	return s, nil
+}
+
+// Close leaves the bus
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+// Tell the bus which properties an engine event changed
+func (s *Server) handleEvent(event engine.Event) {
+	var changed []string
+	switch event.Kind {
+	case engine.TrackChanged, engine.ListChanged, engine.QueueChanged:
+		changed = []string{"Metadata", "PlaybackStatus", "CanGoNext", "CanGoPrevious", "CanPlay", "CanPause", "CanSeek"}
+	case engine.StateChanged:
+		changed = []string{"PlaybackStatus"}
+	case engine.VolumeChanged:
+		changed = []string{"Volume"}
+	case engine.ModeChanged:
+		changed = []string{"LoopStatus", "Shuffle"}
+	case engine.Seeked:
+		s.conn.Emit(objectPath, playerIface+".Seeked", s.eng.Position().Microseconds())
+		return
+	default:
+		return
+	}
+	all := s.playerProperties()
+	values := make(map[string]dbus.Variant, len(changed))
+	for _, name := range changed {
+		values[name] = all[name]
+	}
+	s.conn.Emit(objectPath, propsIface+".PropertiesChanged", playerIface, values, []string{})
+}
+
+func (s *Server) rootProperties() map[string]dbus.Variant {
+	return map[string]dbus.Variant{
+		"CanQuit":             dbus.MakeVariant(false),
+		"CanRaise":            dbus.MakeVariant(false),
+		"HasTrackList":        dbus.MakeVariant(false),
+		"Identity":            dbus.MakeVariant("Skye's Music Player"),
+		"SupportedUriSchemes": dbus.MakeVariant([]string{}),
+		"SupportedMimeTypes":  dbus.MakeVariant([]string{}),
+	}
+}
+
+func (s *Server) playerProperties() map[string]dbus.Variant {
+	_, loaded := s.eng.NowPlaying()
+	return map[string]dbus.Variant{
+		"PlaybackStatus": dbus.MakeVariant(s.playbackStatus()),
+		"LoopStatus":     dbus.MakeVariant(loopStatus[s.eng.Repeat()]),
+		"Rate":           dbus.MakeVariant(1.0),
+		"MinimumRate":    dbus.MakeVariant(1.0),
+		"MaximumRate":    dbus.MakeVariant(1.0),
+		"Shuffle":        dbus.MakeVariant(s.eng.Shuffle()),
+		"Metadata":       dbus.MakeVariant(s.metadata()),
+		"Volume":         dbus.MakeVariant(s.eng.Volume()),
+		"Position":       dbus.MakeVariant(s.eng.Position().Microseconds()),
+		"CanGoNext":      dbus.MakeVariant(loaded),
+		"CanGoPrevious":  dbus.MakeVariant(loaded),
+		"CanPlay":        dbus.MakeVariant(loaded),
+		"CanPause":       dbus.MakeVariant(loaded),
+		"CanSeek":        dbus.MakeVariant(loaded),
+		"CanControl":     dbus.MakeVariant(true),
+	}
+}
+
+func (s *Server) playbackStatus() string {
+	switch _, loaded := s.eng.NowPlaying(); {
+	case !loaded:
+		return "Stopped"
+	case s.eng.IsPlaying():
+		return "Playing"
+	}
+	return "Paused"
+}
+
+// An id for the track playing: its place in the list, or one for whichever
+// queued track is playing
+func (s *Server) trackID() dbus.ObjectPath {
+	if _, loaded := s.eng.NowPlaying(); !loaded {
+		return noTrack
+	}
+	if s.eng.FromQueue() {
+		return "/org/mpris/MediaPlayer2/Track/Queued"
+	}
+	return dbus.ObjectPath(fmt.Sprintf("/org/mpris/MediaPlayer2/Track/%d", s.eng.Current()))
+}
+
+// The track playing in the xesam terms MPRIS uses
+func (s *Server) metadata() map[string]dbus.Variant {
+	track, loaded := s.eng.NowPlaying()
+	m := map[string]dbus.Variant{"mpris:trackid": dbus.MakeVariant(s.trackID())}
+	if !loaded {
+		return m
+	}
+	title := track.Title
+	if title == "" {
+		title = track.Name
+	}
+	m["xesam:title"] = dbus.MakeVariant(title)
+	if path, err := filepath.Abs(track.Path); err == nil {
+		m["xesam:url"] = dbus.MakeVariant((&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String())
+	}
+	if track.Duration > 0 {
+		m["mpris:length"] = dbus.MakeVariant(track.Duration.Microseconds())
+	}
+	if track.Artist != "" {
+		m["xesam:artist"] = dbus.MakeVariant([]string{track.Artist})
+	}
+	if track.Album != "" {
+		m["xesam:album"] = dbus.MakeVariant(track.Album)
+	}
+	if track.Genre != "" {
+		m["xesam:genre"] = dbus.MakeVariant([]string{track.Genre})
+	}
+	if track.TrackNumber > 0 {
+		m["xesam:trackNumber"] = dbus.MakeVariant(int32(track.TrackNumber))
+	}
+	return m
+}
+
+// A failed engine call as a D-Bus error
+func dbusError(err error) *dbus.Error {
+	if err == nil {
+		return nil
+	}
+	return dbus.MakeFailedError(err)
+}
+
+// org.mpris.MediaPlayer2; the player has no window to raise from here and
+// isn't quit from outside
+type root struct{ s *Server }
+
+func (root) Raise() *dbus.Error { return nil }
+func (root) Quit() *dbus.Error  { return nil }
+
+// org.mpris.MediaPlayer2.Player
+type player struct{ s *Server }
+
+func (p player) Next() *dbus.Error     { return dbusError(p.s.eng.Next()) }
+func (p player) Previous() *dbus.Error { return dbusError(p.s.eng.Previous()) }
+func (p player) Pause() *dbus.Error    { p.s.eng.Pause(); return nil }
+func (p player) Play() *dbus.Error     { p.s.eng.Play(); return nil }
+
+func (p player) PlayPause() *dbus.Error {
+	p.s.eng.TogglePlay()
+	return nil
+}
+
+// Stop pauses and goes back to the start of the track
+func (p player) Stop() *dbus.Error {
+	p.s.eng.Pause()
+	if _, loaded := p.s.eng.NowPlaying(); !loaded {
+		return nil
+	}
+	return dbusError(p.s.eng.Seek(0))
+}
+
+// SeekBy is MPRIS's Seek, named so as not to look like io.Seeker's. It moves
+// by offset microseconds; going past the end skips to the next track.
+func (p player) SeekBy(offset int64) *dbus.Error {
+	position := p.s.eng.Position() + time.Duration(offset)*time.Microsecond
+	if position >= p.s.eng.Duration() {
+		return p.Next()
+	}
+	if position < 0 {
+		position = 0
+	}
+	return dbusError(p.s.eng.Seek(position))
+}
+
+// SetPosition jumps within the track; it's ignored if that track has stopped
+// playing in the meantime or the position is out of range
+func (p player) SetPosition(track dbus.ObjectPath, position int64) *dbus.Error {
+	to := time.Duration(position) * time.Microsecond
+	if track != p.s.trackID() || to < 0 || to > p.s.eng.Duration() {
+		return nil
+	}
+	return dbusError(p.s.eng.Seek(to))
+}
+
+func (player) OpenUri(uri string) *dbus.Error {
+	return dbus.MakeFailedError(errors.New("opening URIs is not supported"))
+}
+
+// org.freedesktop.DBus.Properties, worked out when asked so Position is current
+type properties struct{ s *Server }
+
+func (p properties) all(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	switch iface {
+	case rootIface:
+		return p.s.rootProperties(), nil
+	case playerIface:
+		return p.s.playerProperties(), nil
+	}
+	return nil, dbus.NewError("org.freedesktop.DBus.Error.UnknownInterface", []any{"no interface " + iface})
+}
+
+func (p properties) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	all, err := p.all(iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	value, ok := all[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []any{"no property " + name})
+	}
+	return value, nil
+}
+
+func (p properties) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	return p.all(iface)
+}
+
+// Set changes the volume, shuffle or loop status. The engine's events then
+// announce the change, whoever made it.
+func (p properties) Set(iface, name string, value dbus.Variant) *dbus.Error {
+	invalid := dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []any{"wrong type or value for " + name})
+	if iface != playerIface {
+		return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly", []any{name + " is read-only"})
+	}
+	switch name {
+	case "Volume":
+		volume, ok := value.Value().(float64)
+		if !ok {
+			return invalid
+		}
+		p.s.eng.SetVolume(volume)
+	case "Shuffle":
+		shuffle, ok := value.Value().(bool)
+		if !ok {
+			return invalid
+		}
+		p.s.eng.SetShuffle(shuffle)
+	case "LoopStatus":
+		status, ok := value.Value().(string)
+		if !ok {
+			return invalid
+		}
+		for mode, s := range loopStatus {
+			if s == status {
+				p.s.eng.SetRepeat(mode)
+				return nil
+			}
+		}
+		return invalid
+	case "Rate":
+		// Only normal speed is supported; MPRIS says to ignore other rates
+	default:
+		return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly", []any{name + " is read-only"})
+	}
+	return nil
+}
+
+// What the object offers, for clients that introspect it
+const introspection = `<node>
+  <interface name="org.freedesktop.DBus.Introspectable">
+    <method name="Introspect"><arg name="data" direction="out" type="s"/></method>
+  </interface>
+  <interface name="org.freedesktop.DBus.Properties">
+    <method name="Get">
+      <arg name="interface" direction="in" type="s"/>
+      <arg name="property" direction="in" type="s"/>
+      <arg name="value" direction="out" type="v"/>
+    </method>
+    <method name="GetAll">
+      <arg name="interface" direction="in" type="s"/>
+      <arg name="properties" direction="out" type="a{sv}"/>
+    </method>
+    <method name="Set">
+      <arg name="interface" direction="in" type="s"/>
+      <arg name="property" direction="in" type="s"/>
+      <arg name="value" direction="in" type="v"/>
+    </method>
+    <signal name="PropertiesChanged">
+      <arg name="interface" type="s"/>
+      <arg name="changed" type="a{sv}"/>
+      <arg name="invalidated" type="as"/>
+    </signal>
+  </interface>
+  <interface name="org.mpris.MediaPlayer2">
+    <method name="Raise"/>
+    <method name="Quit"/>
+    <property name="CanQuit" type="b" access="read"/>
+    <property name="CanRaise" type="b" access="read"/>
+    <property name="HasTrackList" type="b" access="read"/>
+    <property name="Identity" type="s" access="read"/>
+    <property name="SupportedUriSchemes" type="as" access="read"/>
+    <property name="SupportedMimeTypes" type="as" access="read"/>
+  </interface>
+  <interface name="org.mpris.MediaPlayer2.Player">
+    <method name="Next"/>
+    <method name="Previous"/>
+    <method name="Pause"/>
+    <method name="PlayPause"/>
+    <method name="Stop"/>
+    <method name="Play"/>
+    <method name="Seek"><arg name="Offset" direction="in" type="x"/></method>
+    <method name="SetPosition">
+      <arg name="TrackId" direction="in" type="o"/>
+      <arg name="Position" direction="in" type="x"/>
+    </method>
+    <method name="OpenUri"><arg name="Uri" direction="in" type="s"/></method>
+    <signal name="Seeked"><arg name="Position" type="x"/></signal>
+    <property name="PlaybackStatus" type="s" access="read"/>
+    <property name="LoopStatus" type="s" access="readwrite"/>
+    <property name="Rate" type="d" access="readwrite"/>
+    <property name="Shuffle" type="b" access="readwrite"/>
+    <property name="Metadata" type="a{sv}" access="read"/>
+    <property name="Volume" type="d" access="readwrite"/>
+    <property name="Position" type="x" access="read"/>
+    <property name="MinimumRate" type="d" access="read"/>
+    <property name="MaximumRate" type="d" access="read"/>
+    <property name="CanGoNext" type="b" access="read"/>
+    <property name="CanGoPrevious" type="b" access="read"/>
+    <property name="CanPlay" type="b" access="read"/>
+    <property name="CanPause" type="b" access="read"/>
+    <property name="CanSeek" type="b" access="read"/>
+    <property name="CanControl" type="b" access="read"/>
+  </interface>
+</node>`
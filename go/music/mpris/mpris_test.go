@@ -0,0 +1,190 @@
+//go:build linux
+
+package mpris
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"music/engine"
+	"music/engine/enginetest"
+)
+
+// Start a bus of the test's own, so nothing on the desktop's bus is touched
+func privateBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+	cmd := exec.Command(daemon, "--session", "--print-address", "--nofork")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading the bus address: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+func connect(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// A player of two ten-second tracks on a private bus, and a client connection
+// watching its signals
+func newTestPlayer(t *testing.T) (*engine.Engine, dbus.BusObject, chan *dbus.Signal) {
+	t.Helper()
+	address := privateBus(t)
+
+	eng := engine.New(func(path string) (engine.Source, error) {
+		return enginetest.Silence(10*time.Second, 100), nil
+	}, 100)
+	t.Cleanup(func() { eng.Close() })
+	if err := eng.Load([]engine.Track{{Name: "a", Path: "a", Title: "First"}, {Name: "b", Path: "b", Title: "Second"}}); err != nil {
+		t.Fatal(err)
+	}
+	eng.Update()
+	if _, err := Export(connect(t, address), eng); err != nil {
+		t.Fatal(err)
+	}
+
+	client := connect(t, address)
+	if err := client.AddMatchSignal(dbus.WithMatchObjectPath(objectPath)); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 100)
+	client.Signal(signals)
+	return eng, client.Object(busName, objectPath), signals
+}
+
+// Deliver the engine's events and wait for the signal named, skipping others
+func waitForSignal(t *testing.T, eng *engine.Engine, signals chan *dbus.Signal, name string) *dbus.Signal {
+	t.Helper()
+	eng.Update()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Name == name {
+				return signal
+			}
+		case <-timeout:
+			t.Fatalf("no %s signal", name)
+			return nil
+		}
+	}
+}
+
+// Wait for a PropertiesChanged signal that includes property
+func waitForChange(t *testing.T, eng *engine.Engine, signals chan *dbus.Signal, property string) dbus.Variant {
+	t.Helper()
+	for {
+		signal := waitForSignal(t, eng, signals, propsIface+".PropertiesChanged")
+		if len(signal.Body) != 3 || signal.Body[0] != playerIface {
+			t.Fatalf("PropertiesChanged with %v", signal.Body)
+		}
+		changed := signal.Body[1].(map[string]dbus.Variant)
+		if value, ok := changed[property]; ok {
+			return value
+		}
+	}
+}
+
+func TestPlayerControls(t *testing.T) {
+	eng, player, signals := newTestPlayer(t)
+
+	if err := player.Call(playerIface+".PlayPause", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	if got := waitForChange(t, eng, signals, "PlaybackStatus"); got.Value() != "Paused" || eng.IsPlaying() {
+		t.Errorf("PlayPause: status %v, want Paused", got)
+	}
+	player.Call(playerIface+".PlayPause", 0)
+	if got := waitForChange(t, eng, signals, "PlaybackStatus"); got.Value() != "Playing" {
+		t.Errorf("PlayPause again: status %v, want Playing", got)
+	}
+
+	if err := player.Call(playerIface+".Next", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	metadata := waitForChange(t, eng, signals, "Metadata").Value().(map[string]dbus.Variant)
+	if metadata["xesam:title"].Value() != "Second" || metadata["mpris:trackid"].Value() != dbus.ObjectPath("/org/mpris/MediaPlayer2/Track/1") {
+		t.Errorf("Next: metadata %v, want the second track", metadata)
+	}
+
+	// Seek is relative and SetPosition absolute, both in microseconds
+	if err := player.Call(playerIface+".Seek", 0, int64(3*time.Second/time.Microsecond)).Err; err != nil {
+		t.Fatal(err)
+	}
+	if got := waitForSignal(t, eng, signals, playerIface+".Seeked").Body[0]; got != int64(3e6) {
+		t.Errorf("Seek: Seeked to %v, want 3s", got)
+	}
+	trackID := dbus.ObjectPath("/org/mpris/MediaPlayer2/Track/1")
+	if err := player.Call(playerIface+".SetPosition", 0, trackID, int64(7e6)).Err; err != nil {
+		t.Fatal(err)
+	}
+	if got := waitForSignal(t, eng, signals, playerIface+".Seeked").Body[0]; got != int64(7e6) || eng.Position() != 7*time.Second {
+		t.Errorf("SetPosition: Seeked to %v, engine at %v, want 7s", got, eng.Position())
+	}
+	// A position for a track that's no longer playing is ignored
+	player.Call(playerIface+".SetPosition", 0, dbus.ObjectPath("/org/mpris/MediaPlayer2/Track/0"), int64(1e6))
+	if eng.Position() != 7*time.Second {
+		t.Errorf("SetPosition for another track moved to %v", eng.Position())
+	}
+
+	if err := player.Call(propsIface+".Set", 0, playerIface, "Volume", dbus.MakeVariant(0.25)).Err; err != nil {
+		t.Fatal(err)
+	}
+	if got := waitForChange(t, eng, signals, "Volume"); got.Value() != 0.25 || eng.Volume() != 0.25 {
+		t.Errorf("Set Volume: signalled %v, engine at %g, want 0.25", got, eng.Volume())
+	}
+	if err := player.Call(propsIface+".Set", 0, playerIface, "Volume", dbus.MakeVariant("loud")).Err; err == nil {
+		t.Error("setting the volume to a string succeeded")
+	}
+}
+
+func TestProperties(t *testing.T) {
+	eng, player, _ := newTestPlayer(t)
+	eng.SetRepeat(engine.RepeatOne)
+
+	var all map[string]dbus.Variant
+	if err := player.Call(propsIface+".GetAll", 0, playerIface).Store(&all); err != nil {
+		t.Fatal(err)
+	}
+	if all["PlaybackStatus"].Value() != "Playing" || all["LoopStatus"].Value() != "Track" ||
+		all["CanGoNext"].Value() != true || all["Volume"].Value() != 1.0 {
+		t.Errorf("got %v", all)
+	}
+
+	var identity dbus.Variant
+	if err := player.Call(propsIface+".Get", 0, rootIface, "Identity").Store(&identity); err != nil {
+		t.Fatal(err)
+	}
+	if identity.Value() != "Skye's Music Player" {
+		t.Errorf("Identity %v", identity)
+	}
+	if err := player.Call(propsIface+".Set", 0, playerIface, "Position", dbus.MakeVariant(int64(0))).Err; err == nil {
+		t.Error("setting the read-only Position succeeded")
+	}
+}
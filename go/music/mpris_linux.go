@@ -0,0 +1,17 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+
+	"music/engine"
+	"music/mpris"
+)
+
+// Let desktop media keys and panel widgets see and control playback
+func startMPRIS(eng *engine.Engine) {
+	if _, err := mpris.Start(eng); err != nil {
+		fmt.Println("Error connecting to D-Bus for media controls:", err)
+	}
+}
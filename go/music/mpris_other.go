@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+import "music/engine"
+
+// MPRIS is for Linux desktops; elsewhere there's nothing to connect to
+func startMPRIS(eng *engine.Engine) {}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"music/engine"
+)
+
+// Playlist files the player can open and save
+var playlistExtensions = []string{".m3u", ".m3u8", ".pls"}
+
+func isPlaylistFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range playlistExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// Read an M3U/M3U8 or PLS playlist. Entries are relative to the playlist's own
+// folder unless absolute; missing files, unsupported formats and web streams
+// are skipped.
+func readPlaylist(path string) ([]engine.Track, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+
+	type entry struct {
+		file  string
+		title string
+	}
+	var entries []entry
+	if strings.ToLower(filepath.Ext(path)) == ".pls" {
+		// FileN=, TitleN= pairs in a single [playlist] section
+		numbered := make(map[int]*entry)
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+			if !ok {
+				continue
+			}
+			for _, prefix := range []string{"File", "Title"} {
+				if !strings.HasPrefix(key, prefix) {
+					continue
+				}
+				n, err := strconv.Atoi(key[len(prefix):])
+				if err != nil {
+					continue
+				}
+				if numbered[n] == nil {
+					numbered[n] = &entry{}
+				}
+				if prefix == "File" {
+					numbered[n].file = value
+				} else {
+					numbered[n].title = value
+				}
+			}
+		}
+		numbers := make([]int, 0, len(numbered))
+		for n := range numbered {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		for _, n := range numbers {
+			entries = append(entries, *numbered[n])
+		}
+	} else {
+		// One path per line, optionally preceded by #EXTINF:seconds,title
+		title := ""
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			switch {
+			case line == "":
+			case strings.HasPrefix(line, "#EXTINF:"):
+				_, title, _ = strings.Cut(line, ",")
+			case strings.HasPrefix(line, "#"):
+			default:
+				entries = append(entries, entry{file: line, title: strings.TrimSpace(title)})
+				title = ""
+			}
+		}
+	}
+
+	base := filepath.Dir(path)
+	var tracks []engine.Track
+	for _, e := range entries {
+		file := playlistEntryPath(base, e.file)
+		if file == "" || !isAudioFile(file) {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		track := newTrack(file)
+		if track.Title == "" {
+			track.Title = e.title
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// Resolve a playlist entry to a local path, or "" for anything that isn't a file
+func playlistEntryPath(base, entry string) string {
+	if strings.HasPrefix(entry, "file://") {
+		u, err := url.Parse(entry)
+		if err != nil {
+			return ""
+		}
+		return filepath.FromSlash(u.Path)
+	}
+	if strings.Contains(entry, "://") {
+		return ""
+	}
+	// Playlists made on Windows use backslashes
+	entry = filepath.FromSlash(strings.ReplaceAll(entry, `\`, "/"))
+	if filepath.IsAbs(entry) {
+		return entry
+	}
+	return filepath.Join(base, entry)
+}
+
+// Write tracks to a playlist, as PLS if path ends in .pls and M3U8 otherwise.
+// Paths are written relative to the playlist so the folder can be moved as a whole.
+func writePlaylist(path string, tracks []engine.Track) error {
+	base := filepath.Dir(path)
+	relative := func(file string) string {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return filepath.ToSlash(file)
+		}
+		absBase, err := filepath.Abs(base)
+		if err != nil {
+			return filepath.ToSlash(abs)
+		}
+		rel, err := filepath.Rel(absBase, abs)
+		if err != nil {
+			return filepath.ToSlash(abs)
+		}
+		return filepath.ToSlash(rel)
+	}
+	seconds := func(track engine.Track) int {
+		if track.Duration == 0 {
+			return -1 // unknown
+		}
+		return int(track.Duration.Seconds())
+	}
+
+	var out bytes.Buffer
+	if strings.ToLower(filepath.Ext(path)) == ".pls" {
+		out.WriteString("[playlist]\n")
+		for i, track := range tracks {
+			fmt.Fprintf(&out, "File%d=%s\n", i+1, relative(track.Path))
+			fmt.Fprintf(&out, "Title%d=%s\n", i+1, track.DisplayName())
+			fmt.Fprintf(&out, "Length%d=%d\n", i+1, seconds(track))
+		}
+		fmt.Fprintf(&out, "NumberOfEntries=%d\nVersion=2\n", len(tracks))
+	} else {
+		out.WriteString("#EXTM3U\n")
+		for _, track := range tracks {
+			fmt.Fprintf(&out, "#EXTINF:%d,%s\n%s\n", seconds(track), track.DisplayName(), relative(track.Path))
+		}
+	}
+	return os.WriteFile(path, out.Bytes(), 0644)
+}
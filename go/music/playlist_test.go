@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"music/engine"
+)
+
+// Create a file that passes for a track by its name; it has no tags, so
+// titles come from the playlist
+func touchTrack(t *testing.T, dir, name string) string {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("not really audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPlaylistRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		ext  string
+		want []string // lines the file must have
+	}{
+		{".m3u8", []string{
+			"#EXTM3U",
+			"#EXTINF:180,Band - First Song",
+			"../My Music/01 First Song.mp3",
+			"#EXTINF:-1,second.mp3",
+			"Sub Dir/second.mp3",
+		}},
+		{".pls", []string{
+			"[playlist]",
+			"File1=../My Music/01 First Song.mp3",
+			"Title1=Band - First Song",
+			"Length1=180",
+			"File2=Sub Dir/second.mp3",
+			"Title2=second.mp3",
+			"Length2=-1",
+			"NumberOfEntries=2",
+		}},
+	} {
+		t.Run(test.ext, func(t *testing.T) {
+			dir := t.TempDir()
+			lists := filepath.Join(dir, "Play Lists")
+			tracks := []engine.Track{
+				{
+					Name:     "01 First Song.mp3",
+					Path:     touchTrack(t, dir, "My Music/01 First Song.mp3"),
+					Title:    "First Song",
+					Artist:   "Band",
+					Duration: 3 * time.Minute,
+				},
+				{Name: "second.mp3", Path: touchTrack(t, lists, "Sub Dir/second.mp3")},
+			}
+			path := filepath.Join(lists, "mix"+test.ext)
+			if err := writePlaylist(path, tracks); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(string(data), "\n")
+			for _, want := range test.want {
+				if !slices.Contains(lines, want) {
+					t.Errorf("playlist has no line %q:\n%s", want, data)
+				}
+			}
+
+			got, err := readPlaylist(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tracks) {
+				t.Fatalf("read back %d tracks, want %d", len(got), len(tracks))
+			}
+			for i, track := range got {
+				if track.Path != tracks[i].Path || track.DisplayName() != tracks[i].DisplayName() {
+					t.Errorf("track %d read back as %q at %s, want %q at %s",
+						i, track.DisplayName(), track.Path, tracks[i].DisplayName(), tracks[i].Path)
+				}
+			}
+		})
+	}
+}
+
+func TestReadM3U(t *testing.T) {
+	dir := t.TempDir()
+	first := touchTrack(t, dir, "folder with spaces/a track.mp3")
+	windows := touchTrack(t, dir, "sub/win track.mp3")
+	elsewhere := touchTrack(t, t.TempDir(), "far away.mp3")
+	touchTrack(t, dir, "notes.txt")
+	fileURL := (&url.URL{Scheme: "file", Path: filepath.ToSlash(elsewhere)}).String()
+
+	// A byte order mark, comments, a web stream and entries that aren't
+	// tracks or don't exist are all passed over
+	path := filepath.Join(dir, "list.m3u")
+	playlist := "\xEF\xBB\xBF#EXTM3U\n" +
+		"#EXTINF:123,Some Artist - A Title\n" +
+		"folder with spaces/a track.mp3\n" +
+		"\n" +
+		"# just a comment\n" +
+		"missing.mp3\n" +
+		"http://radio.example/stream.mp3\n" +
+		"#EXTINF:-1, Made on Windows \n" +
+		"sub\\win track.mp3\n" +
+		"notes.txt\n" +
+		fileURL + "\n"
+	if err := os.WriteFile(path, []byte(playlist), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tracks, err := readPlaylist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ path, title string }{
+		{first, "Some Artist - A Title"},
+		{windows, "Made on Windows"},
+		{elsewhere, ""},
+	}
+	if len(tracks) != len(want) {
+		t.Fatalf("got %d tracks %v, want %d", len(tracks), tracks, len(want))
+	}
+	for i, track := range tracks {
+		if track.Path != want[i].path || track.Title != want[i].title {
+			t.Errorf("track %d is %q at %s, want %q at %s", i, track.Title, track.Path, want[i].title, want[i].path)
+		}
+	}
+}
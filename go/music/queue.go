@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+
+	"music/engine"
+)
+
+// Area below the queue panel's title where the queued tracks go
+func (p *Player) queueRows() image.Rectangle {
+	q := p.ui.queue
+	return image.Rect(q.Min.X, q.Min.Y+rowHeight()+4, q.Max.X, q.Max.Y)
+}
+
+func (p *Player) visibleQueueRows() int {
+	return p.queueRows().Dy() / rowHeight()
+}
+
+// Add tracks to the end of the queue, or to its front when Shift is held
+func (p *Player) queueTracks(tracks []engine.Track, name string) error {
+	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		p.setStatus("Playing next: " + name)
+		return p.engine.PlayNext(tracks...)
+	}
+	p.setStatus("Queued: " + name)
+	return p.engine.Enqueue(tracks...)
+}
+
+// The queued track under the mouse, or -1
+func (p *Player) queueRowAt(mouse image.Point) int {
+	rows := p.queueRows()
+	if !mouse.In(rows) {
+		return -1
+	}
+	row := p.queueScroll + (mouse.Y-rows.Min.Y)/rowHeight()
+	if row >= len(p.queue) {
+		return -1
+	}
+	return row
+}
+
+// Where a dragged track lands: the row under the mouse, or the nearest end
+func (p *Player) dropRow(mouse image.Point) int {
+	row := p.queueScroll + (mouse.Y-p.queueRows().Min.Y)/rowHeight()
+	if mouse.Y < p.queueRows().Min.Y || row < 0 {
+		row = 0
+	}
+	if row >= len(p.queue) {
+		row = len(p.queue) - 1
+	}
+	return row
+}
+
+// Clicking a queued track selects it for Delete and [ ], dragging moves it,
+// right-clicking removes it and the wheel scrolls
+func (p *Player) updateQueue() {
+	mouseX, mouseY := ebiten.CursorPosition()
+	mouse := image.Pt(mouseX, mouseY)
+
+	if _, wheelY := ebiten.Wheel(); mouse.In(p.ui.queue) {
+		if wheelY > 0 {
+			p.queueScroll -= 3
+		} else if wheelY < 0 {
+			p.queueScroll += 3
+		}
+	}
+	if p.queueScroll > len(p.queue)-p.visibleQueueRows() {
+		p.queueScroll = len(p.queue) - p.visibleQueueRows()
+	}
+	if p.queueScroll < 0 {
+		p.queueScroll = 0
+	}
+
+	// Clicking anywhere else lets Delete and [ ] go back to the current track
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		p.queueSelected = p.queueRowAt(mouse)
+		p.dragging = p.queueSelected
+	}
+	if p.dragging >= 0 && inpututil.IsMouseButtonJustReleased(ebiten.MouseButtonLeft) {
+		if to := p.dropRow(mouse); to != p.dragging {
+			p.engine.MoveQueued(p.dragging, to)
+			p.queueSelected = to
+		}
+		p.dragging = -1
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) {
+		if row := p.queueRowAt(mouse); row >= 0 {
+			p.engine.RemoveQueued(row)
+			p.queueSelected = -1
+		}
+	}
+}
+
+// Move the selected queued track up or down the queue
+func (p *Player) moveQueued(delta int) {
+	to := p.queueSelected + delta
+	if to < 0 || to >= len(p.queue) {
+		return
+	}
+	p.engine.MoveQueued(p.queueSelected, to)
+	p.queueSelected = to
+}
+
+func (p *Player) drawQueue(screen *ebiten.Image) {
+	face := myFont
+	rh := rowHeight()
+	descent := face.Metrics().Descent.Ceil()
+
+	// Title row, level with the filter box
+	panel := p.ui.queue
+	box := image.Rect(panel.Min.X, panel.Min.Y, panel.Max.X, panel.Min.Y+rh)
+	draw.Draw(screen, box, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+	title := "Up next"
+	if len(p.queue) > 0 {
+		title = fmt.Sprintf("Up next (%d)  X Clear", len(p.queue))
+	}
+	text.Draw(screen, title, face, box.Min.X+5, box.Max.Y-descent-2, theme.Text)
+
+	rows := p.queueRows()
+	if len(p.queue) == 0 {
+		text.Draw(screen, "Nothing queued", face, rows.Min.X+10, rows.Min.Y+rh-descent-2, theme.Text)
+		return
+	}
+
+	visible := p.visibleQueueRows()
+	for row := 0; row < visible && p.queueScroll+row < len(p.queue); row++ {
+		i := p.queueScroll + row
+		top := rows.Min.Y + row*rh
+		var color color.Color = theme.Text
+		if i == p.queueSelected {
+			selection := image.Rect(rows.Min.X, top, rows.Max.X-scrollBarWidth-2, top+rh)
+			draw.Draw(screen, selection, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+			color = theme.Highlight
+		}
+		text.Draw(screen, p.queue[i].DisplayName(), face, rows.Min.X+10, top+rh-descent-2, color)
+	}
+
+	// A line where a dragged track will land
+	if p.dragging >= 0 {
+		mouseX, mouseY := ebiten.CursorPosition()
+		to := p.dropRow(image.Pt(mouseX, mouseY))
+		y := rows.Min.Y + (to-p.queueScroll)*rh
+		if to > p.dragging {
+			y += rh
+		}
+		if y >= rows.Min.Y && y <= rows.Max.Y {
+			marker := image.Rect(rows.Min.X, y-1, rows.Max.X-scrollBarWidth-2, y+1)
+			draw.Draw(screen, marker, &image.Uniform{C: theme.Progress}, image.Point{}, draw.Src)
+		}
+	}
+
+	if len(p.queue) > visible {
+		bar := image.Rect(rows.Max.X-scrollBarWidth, rows.Min.Y, rows.Max.X, rows.Max.Y)
+		draw.Draw(screen, bar, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+		thumbTop := bar.Min.Y + bar.Dy()*p.queueScroll/len(p.queue)
+		thumbBottom := bar.Min.Y + bar.Dy()*(p.queueScroll+visible)/len(p.queue)
+		thumb := image.Rect(bar.Min.X, thumbTop, bar.Max.X, thumbBottom)
+		draw.Draw(screen, thumb, &image.Uniform{C: theme.Progress}, image.Point{}, draw.Src)
+	}
+}
@@ -0,0 +1,223 @@
+// Package remote is an HTTP/JSON API for controlling the music player from
+// scripts or other devices. It drives the engine directly, the same as the
+// player's keys do, so it needs no window and can be served with httptest.
+//
+//	GET  /status                   what's playing, position, volume and modes
+//	GET  /tracks                   the track list
+//	GET  /queue                    the play queue
+//	POST /play?index=N             resume, or play track N of the list
+//	POST /pause, /toggle           pause, or pause/resume
+//	POST /next, /previous          skip
+//	POST /seek?position=SECONDS    jump within the current track
+//	POST /volume?volume=0..1       set the volume
+//
+// Parameters may be in the query string or a form body. Actions reply with
+// the new status; failures reply with {"error": "..."}.
+//
+// Every request needs the token the handler was made with, in an
+// "Authorization: Bearer TOKEN" header. A web page can't set that header on
+// another site without the server allowing it, so pages in a browser can't
+// drive the player, and neither can other devices that don't know the token.
+package remote
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"music/engine"
+)
+
+// Track is how a track is described in replies
+type Track struct {
+	Index    int     `json:"index"` // place in the list or queue
+	Name     string  `json:"name"`
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Genre    string  `json:"genre,omitempty"`
+	Path     string  `json:"path"`
+	Duration float64 `json:"duration"` // seconds, 0 until known
+}
+
+// Status is the reply to GET /status and to every action
+type Status struct {
+	Track     *Track  `json:"track"` // nil when nothing is loaded
+	FromQueue bool    `json:"from_queue"`
+	Playing   bool    `json:"playing"`
+	Position  float64 `json:"position"` // seconds
+	Duration  float64 `json:"duration"`
+	Volume    float64 `json:"volume"` // 0 to 1
+	Shuffle   bool    `json:"shuffle"`
+	Repeat    string  `json:"repeat"`
+	Tracks    int     `json:"tracks"` // length of the list
+	Queued    int     `json:"queued"`
+}
+
+func newTrack(index int, t engine.Track) Track {
+	return Track{
+		Index:    index,
+		Name:     t.DisplayName(),
+		Title:    t.Title,
+		Artist:   t.Artist,
+		Album:    t.Album,
+		Genre:    t.Genre,
+		Path:     t.Path,
+		Duration: t.Duration.Seconds(),
+	}
+}
+
+func tracks(list []engine.Track) []Track {
+	out := make([]Track, len(list))
+	for i, t := range list {
+		out[i] = newTrack(i, t)
+	}
+	return out
+}
+
+// StatusOf describes the engine's current state
+func StatusOf(eng *engine.Engine) Status {
+	s := Status{
+		FromQueue: eng.FromQueue(),
+		Playing:   eng.IsPlaying(),
+		Position:  eng.Position().Seconds(),
+		Duration:  eng.Duration().Seconds(),
+		Volume:    eng.Volume(),
+		Shuffle:   eng.Shuffle(),
+		Repeat:    eng.Repeat().String(),
+		Tracks:    len(eng.Tracks()),
+		Queued:    len(eng.Queue()),
+	}
+	if t, ok := eng.NowPlaying(); ok {
+		index := eng.Current()
+		if s.FromQueue {
+			index = -1
+		}
+		track := newTrack(index, t)
+		s.Track = &track
+	}
+	return s
+}
+
+// NewToken makes a random token for Handler
+func NewToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Handler serves the API for an engine to requests carrying token. With an
+// empty token every request is refused.
+func Handler(eng *engine.Engine, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, StatusOf(eng))
+	})
+	mux.HandleFunc("GET /tracks", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, tracks(eng.Tracks()))
+	})
+	mux.HandleFunc("GET /queue", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, tracks(eng.Queue()))
+	})
+
+	mux.HandleFunc("POST /play", action(eng, func(r *http.Request) error {
+		if r.FormValue("index") == "" {
+			eng.Play()
+			return nil
+		}
+		index, err := strconv.Atoi(r.FormValue("index"))
+		if err != nil || index < 0 || index >= len(eng.Tracks()) {
+			return badRequest("index must be a track number from 0")
+		}
+		return eng.PlayTrack(index)
+	}))
+	mux.HandleFunc("POST /pause", action(eng, func(r *http.Request) error {
+		eng.Pause()
+		return nil
+	}))
+	mux.HandleFunc("POST /toggle", action(eng, func(r *http.Request) error {
+		eng.TogglePlay()
+		return nil
+	}))
+	mux.HandleFunc("POST /next", action(eng, func(r *http.Request) error {
+		return eng.Next()
+	}))
+	mux.HandleFunc("POST /previous", action(eng, func(r *http.Request) error {
+		return eng.Previous()
+	}))
+	mux.HandleFunc("POST /seek", action(eng, func(r *http.Request) error {
+		seconds, err := strconv.ParseFloat(r.FormValue("position"), 64)
+		if err != nil || seconds < 0 {
+			return badRequest("position must be a number of seconds")
+		}
+		return eng.Seek(time.Duration(seconds * float64(time.Second)))
+	}))
+	mux.HandleFunc("POST /volume", action(eng, func(r *http.Request) error {
+		volume, err := strconv.ParseFloat(r.FormValue("volume"), 64)
+		if err != nil || volume < 0 || volume > 1 {
+			return badRequest("volume must be from 0 to 1")
+		}
+		eng.SetVolume(volume)
+		return nil
+	}))
+	return authorize(mux, token)
+}
+
+// Refuse requests without the token
+func authorize(next http.Handler, token string) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			fail(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// A request the client got wrong, as opposed to the engine failing
+type requestError string
+
+func (e requestError) Error() string { return string(e) }
+
+func badRequest(message string) error {
+	return requestError(message)
+}
+
+// Run f and reply with the status it leaves the engine in
+func action(eng *engine.Engine, f func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := f(r); err != nil {
+			code := http.StatusInternalServerError
+			var bad requestError
+			if errors.As(err, &bad) {
+				code = http.StatusBadRequest
+			}
+			fail(w, code, err)
+			return
+		}
+		reply(w, StatusOf(eng))
+	}
+}
+
+func reply(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("Error writing remote reply:", err)
+	}
+}
+
+func fail(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,256 @@
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"music/engine"
+	"music/engine/enginetest"
+)
+
+const testToken = "secret"
+
+// An engine whose tracks are all ten seconds long, except "broken", which
+// fails to open
+func newTestServer(t *testing.T) (*engine.Engine, *httptest.Server) {
+	t.Helper()
+	eng := engine.New(func(path string) (engine.Source, error) {
+		if path == "broken" {
+			return nil, errors.New("can't decode broken")
+		}
+		return enginetest.Silence(10*time.Second, 100), nil
+	}, 100)
+	t.Cleanup(func() { eng.Close() })
+	server := httptest.NewServer(Handler(eng, testToken))
+	t.Cleanup(server.Close)
+	return eng, server
+}
+
+func loadTracks(t *testing.T, eng *engine.Engine, paths ...string) {
+	t.Helper()
+	var tracks []engine.Track
+	for _, path := range paths {
+		tracks = append(tracks, engine.Track{Name: path, Path: path, Title: strings.ToUpper(path)})
+	}
+	if err := eng.Load(tracks); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Make a request with the token and decode the reply into v, returning the status code
+func call(t *testing.T, server *httptest.Server, method, path string, form url.Values, v any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("%s %s: content type %q, want JSON", method, path, got)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestGet(t *testing.T) {
+	eng, server := newTestServer(t)
+	loadTracks(t, eng, "a", "b", "c")
+	eng.Enqueue(engine.Track{Name: "q", Path: "q"})
+	eng.SetVolume(0.25)
+
+	var status Status
+	if code := call(t, server, "GET", "/status", nil, &status); code != http.StatusOK {
+		t.Fatalf("status: %d", code)
+	}
+	if status.Track == nil || status.Track.Path != "a" || status.Track.Title != "A" || status.Track.Index != 0 ||
+		!status.Playing || status.Duration != 10 || status.Volume != 0.25 || status.Tracks != 3 ||
+		status.Queued != 1 || status.Repeat != eng.Repeat().String() {
+		t.Errorf("got %+v, track %+v", status, status.Track)
+	}
+
+	var tracks []Track
+	if code := call(t, server, "GET", "/tracks", nil, &tracks); code != http.StatusOK {
+		t.Fatalf("tracks: %d", code)
+	}
+	if len(tracks) != 3 || tracks[2].Index != 2 || tracks[2].Path != "c" || tracks[2].Name != "C" {
+		t.Errorf("got tracks %+v", tracks)
+	}
+
+	var queue []Track
+	if code := call(t, server, "GET", "/queue", nil, &queue); code != http.StatusOK {
+		t.Fatalf("queue: %d", code)
+	}
+	if len(queue) != 1 || queue[0].Path != "q" {
+		t.Errorf("got queue %+v", queue)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	eng, server := newTestServer(t)
+	loadTracks(t, eng, "a", "b", "c")
+	eng.Pause()
+
+	// Without an index it resumes what's there
+	var status Status
+	if code := call(t, server, "POST", "/play", nil, &status); code != http.StatusOK {
+		t.Fatalf("play: %d", code)
+	}
+	if !status.Playing || status.Track.Path != "a" {
+		t.Errorf("got %+v, want track a playing", status)
+	}
+
+	// With one it plays that track, from the query string or a form body
+	if code := call(t, server, "POST", "/play?index=2", nil, &status); code != http.StatusOK {
+		t.Fatalf("play 2: %d", code)
+	}
+	if !status.Playing || status.Track.Path != "c" || eng.Current() != 2 {
+		t.Errorf("got %+v, want track c playing", status)
+	}
+	if code := call(t, server, "POST", "/play", url.Values{"index": {"1"}}, &status); code != http.StatusOK {
+		t.Fatalf("play 1: %d", code)
+	}
+	if status.Track.Path != "b" {
+		t.Errorf("got %+v, want track b", status.Track)
+	}
+}
+
+func TestActions(t *testing.T) {
+	eng, server := newTestServer(t)
+	loadTracks(t, eng, "a", "b", "c")
+
+	var status Status
+	steps := []struct {
+		path  string
+		form  url.Values
+		check func() bool
+	}{
+		{"/pause", nil, func() bool { return !status.Playing }},
+		{"/toggle", nil, func() bool { return status.Playing }},
+		{"/next", nil, func() bool { return status.Track.Path == "b" }},
+		{"/previous", nil, func() bool { return status.Track.Path == "a" }},
+		{"/seek", url.Values{"position": {"4.5"}}, func() bool { return status.Position == 4.5 }},
+		{"/volume", url.Values{"volume": {"0.5"}}, func() bool { return status.Volume == 0.5 && eng.Volume() == 0.5 }},
+	}
+	for _, step := range steps {
+		if code := call(t, server, "POST", step.path, step.form, &status); code != http.StatusOK || !step.check() {
+			t.Errorf("%s: got %d %+v", step.path, code, status)
+		}
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	eng, server := newTestServer(t)
+	loadTracks(t, eng, "a", "b")
+
+	tests := []struct {
+		path string
+		form url.Values
+	}{
+		{"/play", url.Values{"index": {"2"}}},
+		{"/play", url.Values{"index": {"-1"}}},
+		{"/play", url.Values{"index": {"first"}}},
+		{"/volume", url.Values{"volume": {"1.5"}}},
+		{"/volume", url.Values{"volume": {"-0.1"}}},
+		{"/volume", nil},
+		{"/seek", url.Values{"position": {"-3"}}},
+		{"/seek", url.Values{"position": {"soon"}}},
+	}
+	for _, test := range tests {
+		var reply map[string]string
+		if code := call(t, server, "POST", test.path, test.form, &reply); code != http.StatusBadRequest || reply["error"] == "" {
+			t.Errorf("%s %v: got %d %v, want 400 with an error", test.path, test.form, code, reply)
+		}
+	}
+	if eng.Current() != 0 || eng.Volume() != 1 {
+		t.Error("a bad request changed the engine")
+	}
+}
+
+func TestEngineFailure(t *testing.T) {
+	eng, server := newTestServer(t)
+	loadTracks(t, eng, "a", "broken")
+
+	var reply map[string]string
+	if code := call(t, server, "POST", "/play?index=1", nil, &reply); code != http.StatusInternalServerError ||
+		!strings.Contains(reply["error"], "broken") {
+		t.Errorf("got %d %v, want 500 with the engine's error", code, reply)
+	}
+
+	// Seeking with nothing loaded is the engine's failure too, not the request's
+	eng.Load(nil)
+	reply = nil
+	if code := call(t, server, "POST", "/seek?position=1", nil, &reply); code != http.StatusInternalServerError ||
+		reply["error"] == "" {
+		t.Errorf("seek: got %d %v, want 500 with an error", code, reply)
+	}
+}
+
+func TestToken(t *testing.T) {
+	eng, server := newTestServer(t)
+	loadTracks(t, eng, "a", "b")
+
+	for _, auth := range []string{"", "Bearer wrong", "Bearer " + testToken + "x", testToken} {
+		for _, path := range []string{"/status", "/next"} {
+			method := "GET"
+			if path == "/next" {
+				method = "POST"
+			}
+			req, _ := http.NewRequest(method, server.URL+path, nil)
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var reply map[string]string
+			json.NewDecoder(resp.Body).Decode(&reply)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized || reply["error"] == "" {
+				t.Errorf("%s %s with %q: got %d %v, want 401 with an error", method, path, auth, resp.StatusCode, reply)
+			}
+		}
+	}
+	if eng.Current() != 0 {
+		t.Error("a request without the token skipped a track")
+	}
+
+	// A cross-site form post carries no token
+	resp, err := http.PostForm(server.URL+"/volume", url.Values{"volume": {"0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || eng.Volume() != 1 {
+		t.Errorf("form post without the token: got %d and volume %g", resp.StatusCode, eng.Volume())
+	}
+
+	// No token means no access at all
+	open := httptest.NewServer(Handler(eng, ""))
+	defer open.Close()
+	req, _ := http.NewRequest("GET", open.URL+"/status", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("empty token: got %v, %v, want 401", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+}
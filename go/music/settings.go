@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"music/engine"
+)
+
+// Settings are saved in the user's config directory between runs, along with
+// where playback was so the next run can carry on from there
+type Settings struct {
+	Font     string  `json:"font"` // a bundled font name or a path to a font file
+	FontFlag string  `json:"-"`    // a font given with -font, used instead just for this run
+	FontSize float64 `json:"font_size"`
+	Theme    string  `json:"theme"`
+
+	// Seconds the end of each track overlaps the start of the next; 0 plays
+	// them back to back with no gap
+	Crossfade float64 `json:"crossfade"`
+
+	EQPreset   string    `json:"eq_preset"`
+	EQ         []float64 `json:"eq"`          // dB for each band, from the preset or edited by hand
+	ReplayGain string    `json:"replay_gain"` // "off", "track" or "album"
+
+	Source   string  `json:"source"`   // folder or playlist that was open
+	Track    string  `json:"track"`    // path of the track that was playing
+	Position float64 `json:"position"` // seconds into that track
+	Volume   float64 `json:"volume"`
+	Shuffle  bool    `json:"shuffle"`
+	Repeat   string  `json:"repeat"` // "all", "one" or "stop"
+}
+
+// Repeat setting for each engine.RepeatMode
+var repeatModeSettings = []string{"all", "one", "stop"}
+
+// ReplayGain setting for each engine.GainMode
+var gainModeSettings = []string{"off", "track", "album"}
+
+const (
+	defaultFontSize = 12
+	minFontSize     = 8
+	maxFontSize     = 24
+	fontSizeStep    = 2
+	maxCrossfade    = 12
+)
+
+// Crossfade lengths the C key steps through
+var crossfadeSteps = []float64{0, 2, 5, 8, 12}
+
+// The crossfade length after current, wrapping back to none
+func nextCrossfade(current float64) float64 {
+	for _, step := range crossfadeSteps {
+		if step > current {
+			return step
+		}
+	}
+	return 0
+}
+
+// The font to show: one given on the command line, or the saved one
+func (s Settings) font() string {
+	if s.FontFlag != "" {
+		return s.FontFlag
+	}
+	return s.Font
+}
+
+func (s Settings) crossfadeDuration() time.Duration {
+	return time.Duration(s.Crossfade * float64(time.Second))
+}
+
+// The EQ preset after the current one, wrapping back to flat
+func nextEQPreset(current string) engine.EQPreset {
+	for i, preset := range engine.EQPresets {
+		if preset.Name == current {
+			return engine.EQPresets[(i+1)%len(engine.EQPresets)]
+		}
+	}
+	return engine.EQPresets[0]
+}
+
+func (s Settings) repeatMode() engine.RepeatMode {
+	for i, name := range repeatModeSettings {
+		if name == s.Repeat {
+			return engine.RepeatMode(i)
+		}
+	}
+	return engine.RepeatAll
+}
+
+func (s Settings) gainMode() engine.GainMode {
+	for i, name := range gainModeSettings {
+		if name == s.ReplayGain {
+			return engine.GainMode(i)
+		}
+	}
+	return engine.GainOff
+}
+
+// Set up the engine's playback options from the settings
+func (s Settings) applyTo(eng *engine.Engine) {
+	eng.SetVolume(s.Volume)
+	eng.SetShuffle(s.Shuffle)
+	eng.SetRepeat(s.repeatMode())
+	eng.SetCrossfade(s.crossfadeDuration())
+	var gains engine.EQGains
+	copy(gains[:], s.EQ)
+	eng.SetEQ(gains)
+	eng.SetGainMode(s.gainMode())
+}
+
+func defaultSettings() Settings {
+	return Settings{
+		Font:     fontNames[0],
+		FontSize: defaultFontSize,
+		Theme:    themes[0].Name,
+		EQPreset: engine.EQPresets[0].Name,
+		Source:   "mp3",
+		Volume:   1,
+		Repeat:   repeatModeSettings[0],
+	}
+}
+
+// Location of the settings file, e.g. ~/.config/skyes-music-player/settings.json
+func settingsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "skyes-music-player", "settings.json"), nil
+}
+
+// The settings file as last read or written, so saving unchanged settings
+// doesn't write it again
+var savedSettings []byte
+
+// Load saved settings; anything missing or unreadable keeps its default
+func loadSettings() Settings {
+	settings := defaultSettings()
+	path, err := settingsPath()
+	if err != nil {
+		return settings
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return settings
+	}
+	json.Unmarshal(data, &settings)
+	if settings.FontSize < minFontSize || settings.FontSize > maxFontSize {
+		settings.FontSize = defaultFontSize
+	}
+	if settings.Crossfade < 0 || settings.Crossfade > maxCrossfade {
+		settings.Crossfade = 0
+	}
+	if settings.Volume < 0 || settings.Volume > 1 {
+		settings.Volume = 1
+	}
+	savedSettings = data
+	return settings
+}
+
+// Save settings for the next run, if they changed since they were last saved
+func (s Settings) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, savedSettings) {
+		return nil
+	}
+	path, err := settingsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	savedSettings = data
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Point the settings file at a fresh directory and forget what was saved
+func tempSettings(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("AppData", dir)
+	t.Setenv("HOME", dir)
+	savedSettings = nil
+	t.Cleanup(func() { savedSettings = nil })
+	path, err := settingsPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSaveSettings(t *testing.T) {
+	path := tempSettings(t)
+	settings := Settings{Font: "bundled", FontSize: 14, Volume: 0.5, Track: "a.mp3", Position: 12}
+	if err := settings.save(); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadSettings(); got.Font != "bundled" || got.FontSize != 14 || got.Volume != 0.5 ||
+		got.Track != "a.mp3" || got.Position != 12 {
+		t.Errorf("loaded %+v, want what was saved", got)
+	}
+
+	// Saving the same settings again leaves the file alone
+	if err := os.WriteFile(path, []byte("left alone"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := settings.save(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "left alone" {
+		t.Errorf("unchanged settings were written again: %s", data)
+	}
+
+	// Anything changed is written
+	settings.Position = 30
+	if err := settings.save(); err != nil {
+		t.Fatal(err)
+	}
+	var saved Settings
+	if data, _ := os.ReadFile(path); json.Unmarshal(data, &saved) != nil || saved.Position != 30 {
+		t.Errorf("changed settings weren't written: %s", data)
+	}
+}
+
+func TestSaveSettingsFontFlag(t *testing.T) {
+	path := tempSettings(t)
+	settings := Settings{Font: "bundled", FontFlag: "/tmp/other.ttf", FontSize: 12, Volume: 1}
+	if got := settings.font(); got != "/tmp/other.ttf" {
+		t.Errorf("font() = %q, want the one from the flag", got)
+	}
+	if err := settings.save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "other.ttf") {
+		t.Errorf("saved %s, want the chosen font and no flag", data)
+	}
+	if got := loadSettings(); got.font() != "bundled" {
+		t.Errorf("next run's font is %q, want the chosen one", got.font())
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"music/engine"
+)
+
+// Tags read from a track's ID3v1 or ID3v2 tag, or the Vorbis comments of a
+// FLAC or Ogg file
+type Tags struct {
+	Title       string
+	Artist      string
+	Album       string
+	TrackNumber int
+	Genre       string
+	Gain        engine.ReplayGain
+	Artwork     []byte // embedded cover image (JPEG or PNG), only read when asked for
+}
+
+var errNoTags = errors.New("no ID3 tag")
+
+// Read tags from a file. ID3v2 at the start of the file is preferred; Vorbis
+// comments and then ID3v1 at the end fill in anything it lacks. Artwork is
+// skipped unless withArtwork is set so scanning a large library stays cheap.
+func readTags(path string, withArtwork bool) (Tags, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer file.Close()
+
+	tags, err := readID3v2(file, withArtwork)
+	if err != nil && err != errNoTags {
+		return tags, err
+	}
+	if comments, err := readVorbisComments(file, withArtwork); err == nil {
+		tags.fillFrom(comments)
+	}
+	if v1, err := readID3v1(file); err == nil {
+		tags.fillFrom(v1)
+	}
+	return tags, nil
+}
+
+// Copy any fields t is missing from other
+func (t *Tags) fillFrom(other Tags) {
+	if t.Title == "" {
+		t.Title = other.Title
+	}
+	if t.Artist == "" {
+		t.Artist = other.Artist
+	}
+	if t.Album == "" {
+		t.Album = other.Album
+	}
+	if t.TrackNumber == 0 {
+		t.TrackNumber = other.TrackNumber
+	}
+	if t.Genre == "" {
+		t.Genre = other.Genre
+	}
+	if !t.Gain.HasTrack {
+		t.Gain.TrackGain, t.Gain.TrackPeak, t.Gain.HasTrack = other.Gain.TrackGain, other.Gain.TrackPeak, other.Gain.HasTrack
+	}
+	if !t.Gain.HasAlbum {
+		t.Gain.AlbumGain, t.Gain.AlbumPeak, t.Gain.HasAlbum = other.Gain.AlbumGain, other.Gain.AlbumPeak, other.Gain.HasAlbum
+	}
+	if t.Artwork == nil {
+		t.Artwork = other.Artwork
+	}
+}
+
+// Take a ReplayGain value from a user-defined text frame or Vorbis comment,
+// e.g. REPLAYGAIN_TRACK_GAIN=-6.54 dB
+func (t *Tags) setReplayGain(key, value string) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(value, "dB"), "db"))
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+	switch strings.ToUpper(key) {
+	case "REPLAYGAIN_TRACK_GAIN":
+		t.Gain.TrackGain, t.Gain.HasTrack = v, true
+	case "REPLAYGAIN_TRACK_PEAK":
+		t.Gain.TrackPeak = v
+	case "REPLAYGAIN_ALBUM_GAIN":
+		t.Gain.AlbumGain, t.Gain.HasAlbum = v, true
+	case "REPLAYGAIN_ALBUM_PEAK":
+		t.Gain.AlbumPeak = v
+	}
+}
+
+// ID3v1 is a fixed 128 byte block at the very end of the file
+func readID3v1(file io.ReadSeeker) (Tags, error) {
+	block := make([]byte, 128)
+	if _, err := file.Seek(-128, io.SeekEnd); err != nil {
+		return Tags{}, errNoTags
+	}
+	if _, err := io.ReadFull(file, block); err != nil {
+		return Tags{}, err
+	}
+	if string(block[:3]) != "TAG" {
+		return Tags{}, errNoTags
+	}
+
+	field := func(b []byte) string {
+		return strings.TrimSpace(strings.TrimRight(latin1(b), "\x00"))
+	}
+	tags := Tags{
+		Title:  field(block[3:33]),
+		Artist: field(block[33:63]),
+		Album:  field(block[63:93]),
+	}
+	// ID3v1.1 stores the track number in the last byte of the comment
+	if block[125] == 0 && block[126] != 0 {
+		tags.TrackNumber = int(block[126])
+	}
+	if int(block[127]) < len(id3Genres) {
+		tags.Genre = id3Genres[block[127]]
+	}
+	return tags, nil
+}
+
+// Most of an ID3v2 tag that is read, which leaves room for large cover art
+const maxID3v2Tag = 16 << 20
+
+// ID3v2.2, 2.3 and 2.4 tags sit at the start of the file
+func readID3v2(file io.ReadSeeker, withArtwork bool) (Tags, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return Tags{}, errNoTags
+	}
+	if string(header[:3]) != "ID3" {
+		return Tags{}, errNoTags
+	}
+	version := header[3]
+	flags := header[5]
+	size := synchsafe(header[6:10])
+	if version < 2 || version > 4 {
+		return Tags{}, errNoTags
+	}
+
+	// The size comes from the file, so read through a limit rather than
+	// trusting it with an allocation. A tag cut short keeps the frames it has.
+	if size > maxID3v2Tag {
+		size = maxID3v2Tag
+	}
+	data, err := io.ReadAll(io.LimitReader(file, int64(size)))
+	if err != nil {
+		return Tags{}, err
+	}
+	if flags&0x80 != 0 && version < 4 {
+		data = removeUnsync(data)
+	}
+
+	// Skip the extended header
+	if flags&0x40 != 0 && len(data) >= 4 {
+		extended := int(binary.BigEndian.Uint32(data[:4]))
+		if version == 4 {
+			extended = synchsafe(data[:4])
+		} else {
+			extended += 4 // v2.3 doesn't count the size field itself
+		}
+		if extended > len(data) {
+			return Tags{}, errNoTags
+		}
+		data = data[extended:]
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	var tags Tags
+	for len(data) >= headerLen && data[0] != 0 {
+		id := string(data[:idLen])
+		var frameSize int
+		var frameFlags uint16
+		switch version {
+		case 2:
+			frameSize = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(data[4:8]))
+			frameFlags = binary.BigEndian.Uint16(data[8:10])
+		case 4:
+			frameSize = synchsafe(data[4:8])
+			frameFlags = binary.BigEndian.Uint16(data[8:10])
+		}
+		if frameSize <= 0 || headerLen+frameSize > len(data) {
+			break
+		}
+		frame := data[headerLen : headerLen+frameSize]
+		data = data[headerLen+frameSize:]
+
+		// Compressed or encrypted frames aren't worth supporting here
+		if version == 3 && frameFlags&0x00C0 != 0 || version == 4 && frameFlags&0x000C != 0 {
+			continue
+		}
+		if version == 4 && frameFlags&0x0002 != 0 {
+			frame = removeUnsync(frame)
+		}
+		if version == 4 && frameFlags&0x0001 != 0 && len(frame) >= 4 {
+			frame = frame[4:] // data length indicator
+		}
+
+		switch id {
+		case "TIT2", "TT2":
+			tags.Title = textFrame(frame)
+		case "TPE1", "TP1":
+			tags.Artist = textFrame(frame)
+		case "TALB", "TAL":
+			tags.Album = textFrame(frame)
+		case "TRCK", "TRK":
+			// Track numbers are often written as "3/12"
+			number, _, _ := strings.Cut(textFrame(frame), "/")
+			tags.TrackNumber, _ = strconv.Atoi(strings.TrimSpace(number))
+		case "TCON", "TCO":
+			tags.Genre = genreName(textFrame(frame))
+		case "TXXX", "TXX":
+			// A description and a value
+			if len(frame) > 1 {
+				key, rest := decodeText(frame[0], frame[1:])
+				value, _ := decodeText(frame[0], rest)
+				tags.setReplayGain(key, value)
+			}
+		case "APIC", "PIC":
+			if withArtwork && tags.Artwork == nil {
+				tags.Artwork = pictureFrame(frame, version)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// Genres numbered as in ID3v1, with Winamp's additions
+var id3Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House", "Dance Hall",
+}
+
+// Genre frames may hold a name, an ID3v1 number like "17", or a reference
+// like "(17)" optionally followed by a name
+func genreName(genre string) string {
+	if strings.HasPrefix(genre, "((") {
+		return genre[1:] // an escaped bracket
+	}
+	if strings.HasPrefix(genre, "(") {
+		number, rest, ok := strings.Cut(genre[1:], ")")
+		if ok && strings.TrimSpace(rest) != "" {
+			return strings.TrimSpace(rest)
+		}
+		genre = number
+	}
+	if n, err := strconv.Atoi(genre); err == nil {
+		if n >= 0 && n < len(id3Genres) {
+			return id3Genres[n]
+		}
+		return ""
+	}
+	return genre
+}
+
+// Sizes in ID3v2 headers use 7 bits per byte
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// Undo unsynchronisation, which inserts a zero byte after every 0xFF
+func removeUnsync(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+// Decode a text frame: one encoding byte followed by the text
+func textFrame(frame []byte) string {
+	if len(frame) < 1 {
+		return ""
+	}
+	// v2.4 allows several values separated by NUL; decodeText keeps the first
+	text, _ := decodeText(frame[0], frame[1:])
+	return strings.TrimSpace(text)
+}
+
+// Extract the image bytes from an APIC (v2.3/2.4) or PIC (v2.2) frame
+func pictureFrame(frame []byte, version byte) []byte {
+	if len(frame) < 2 {
+		return nil
+	}
+	encoding := frame[0]
+	rest := frame[1:]
+	if version == 2 {
+		// 3 character image format instead of a MIME type
+		if len(rest) < 3 {
+			return nil
+		}
+		rest = rest[3:]
+	} else {
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return nil
+		}
+		rest = rest[end+1:]
+	}
+	if len(rest) < 1 {
+		return nil
+	}
+	rest = rest[1:] // picture type
+	_, rest = decodeText(encoding, rest)
+	if len(rest) == 0 {
+		return nil
+	}
+	return rest
+}
+
+// Decode one NUL-terminated string in the given ID3 encoding and return it along
+// with whatever follows the terminator. Unterminated text runs to the end of data.
+func decodeText(encoding byte, data []byte) (string, []byte) {
+	switch encoding {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		end := len(data)
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+		rest := data[len(data):]
+		if end+2 <= len(data) {
+			rest = data[end+2:]
+		}
+		text := data[:end]
+		bigEndian := encoding == 2
+		if len(text) >= 2 && text[0] == 0xFE && text[1] == 0xFF {
+			bigEndian, text = true, text[2:]
+		} else if len(text) >= 2 && text[0] == 0xFF && text[1] == 0xFE {
+			bigEndian, text = false, text[2:]
+		}
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = binary.BigEndian.Uint16(text[2*i:])
+			} else {
+				units[i] = binary.LittleEndian.Uint16(text[2*i:])
+			}
+		}
+		return string(utf16.Decode(units)), rest
+	default: // 0 is ISO-8859-1, 3 is UTF-8
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			end = len(data)
+		}
+		rest := data[len(data):]
+		if end+1 <= len(data) {
+			rest = data[end+1:]
+		}
+		if encoding == 3 {
+			return string(data[:end]), rest
+		}
+		return latin1(data[:end]), rest
+	}
+}
+
+// ISO-8859-1 bytes map directly onto the first 256 code points
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	"music/engine"
+)
+
+// An ID3v2 frame with its header in the given tag version's layout
+func id3Frame(version byte, id string, body []byte) []byte {
+	var header []byte
+	switch version {
+	case 2:
+		n := len(body)
+		header = append([]byte(id), byte(n>>16), byte(n>>8), byte(n))
+	case 3:
+		header = binary.BigEndian.AppendUint32([]byte(id), uint32(len(body)))
+		header = append(header, 0, 0)
+	case 4:
+		header = append([]byte(id), synchsafeBytes(len(body))...)
+		header = append(header, 0, 0)
+	}
+	return append(header, body...)
+}
+
+// A text frame body: an encoding byte then the text
+func id3Text(encoding byte, text string) []byte {
+	return append([]byte{encoding}, text...)
+}
+
+func synchsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
+
+// An ID3v2 tag around frames. A size of -1 means the frames' real size.
+func id3Tag(version byte, size int, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	if size < 0 {
+		size = len(body)
+	}
+	tag := append([]byte{'I', 'D', '3', version, 0, 0}, synchsafeBytes(size)...)
+	return append(tag, body...)
+}
+
+// UTF-16 text with a little-endian byte order mark
+func utf16LE(s string) []byte {
+	out := []byte{0xFF, 0xFE}
+	for _, unit := range utf16.Encode([]rune(s)) {
+		out = binary.LittleEndian.AppendUint16(out, unit)
+	}
+	return out
+}
+
+func TestReadID3v2(t *testing.T) {
+	picture := []byte{0x89, 'P', 'N', 'G', 1, 2, 3}
+	tests := []struct {
+		name string
+		tag  []byte
+		want Tags
+	}{
+		{
+			"v2.2",
+			id3Tag(2, -1,
+				id3Frame(2, "TT2", id3Text(0, "Caf\xe9")),
+				id3Frame(2, "TP1", id3Text(0, "Band")),
+				id3Frame(2, "TAL", id3Text(0, "Record")),
+				id3Frame(2, "TRK", id3Text(0, "3/12")),
+				id3Frame(2, "TCO", id3Text(0, "(17)")),
+				id3Frame(2, "PIC", append([]byte{0, 'P', 'N', 'G', 3, 0}, picture...)),
+			),
+			Tags{Title: "Café", Artist: "Band", Album: "Record", TrackNumber: 3, Genre: "Rock", Artwork: picture},
+		},
+		{
+			"v2.3",
+			id3Tag(3, -1,
+				id3Frame(3, "TIT2", append([]byte{1}, utf16LE("Ünïcode")...)),
+				id3Frame(3, "TPE1", id3Text(0, "Band")),
+				id3Frame(3, "TCON", id3Text(0, "(13)Indie")),
+				id3Frame(3, "TXXX", id3Text(0, "REPLAYGAIN_TRACK_GAIN\x00-6.50 dB")),
+				id3Frame(3, "TXXX", id3Text(0, "REPLAYGAIN_TRACK_PEAK\x000.9")),
+				id3Frame(3, "APIC", append([]byte{0, 'i', 'm', 'a', 'g', 'e', '/', 'p', 'n', 'g', 0, 3, 0}, picture...)),
+			),
+			Tags{
+				Title: "Ünïcode", Artist: "Band", Genre: "Indie", Artwork: picture,
+				Gain: engine.ReplayGain{TrackGain: -6.5, TrackPeak: 0.9, HasTrack: true},
+			},
+		},
+		{
+			"v2.4",
+			id3Tag(4, -1,
+				id3Frame(4, "TIT2", id3Text(3, "Überschrift")),
+				id3Frame(4, "TALB", id3Text(3, "Album\x00Second value")),
+				id3Frame(4, "TRCK", id3Text(3, "7")),
+				id3Frame(4, "TCON", id3Text(3, "Jazz")),
+				// Padding ends the frames
+				make([]byte, 20),
+			),
+			Tags{Title: "Überschrift", Album: "Album", TrackNumber: 7, Genre: "Jazz"},
+		},
+		{
+			// The header promises more than the file holds
+			"truncated",
+			id3Tag(3, 1000,
+				id3Frame(3, "TIT2", id3Text(0, "Kept")),
+				id3Frame(3, "TPE1", id3Text(0, "Cut off")),
+			)[:10+15+13], // the header, the title frame and part of the artist frame
+			Tags{Title: "Kept"},
+		},
+		{
+			// The largest size a header can hold, far beyond the file
+			"oversized",
+			id3Tag(4, 1<<28-1, id3Frame(4, "TIT2", id3Text(3, "Big"))),
+			Tags{Title: "Big"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := readID3v2(bytes.NewReader(test.tag), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != test.want.Title || got.Artist != test.want.Artist || got.Album != test.want.Album ||
+				got.TrackNumber != test.want.TrackNumber || got.Genre != test.want.Genre ||
+				got.Gain != test.want.Gain || !bytes.Equal(got.Artwork, test.want.Artwork) {
+				t.Errorf("got %+v\nwant %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadID3v2Oversized(t *testing.T) {
+	tag := id3Tag(4, 1<<28-1, id3Frame(4, "TIT2", id3Text(3, "Big")))
+	reader := &countingReader{r: io.MultiReader(bytes.NewReader(tag), zeros{})}
+	if _, err := readID3v2(reader, false); err != nil {
+		t.Fatal(err)
+	}
+	if reader.n > 10+maxID3v2Tag {
+		t.Errorf("read %d bytes of a tag claiming %d, want at most %d", reader.n, 1<<28-1, maxID3v2Tag)
+	}
+}
+
+func TestReadID3v2NotATag(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("ID3"), []byte("fLaC\x00\x00\x00\x22"), id3Tag(5, -1)} {
+		if _, err := readID3v2(bytes.NewReader(data), false); err != errNoTags {
+			t.Errorf("%q: got %v, want errNoTags", data, err)
+		}
+	}
+}
+
+func TestReadID3v1(t *testing.T) {
+	block := make([]byte, 128)
+	copy(block, "TAG")
+	copy(block[3:], "Old Title")
+	copy(block[33:], "Old Artist")
+	copy(block[63:], "Old Album")
+	block[126] = 4
+	block[127] = 8
+	got, err := readID3v1(bytes.NewReader(append([]byte("audio"), block...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Tags{Title: "Old Title", Artist: "Old Artist", Album: "Old Album", TrackNumber: 4, Genre: "Jazz"}
+	if got.Title != want.Title || got.Artist != want.Artist || got.Album != want.Album ||
+		got.TrackNumber != want.TrackNumber || got.Genre != want.Genre {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+// A Vorbis comment block: vendor string, count, then KEY=value strings
+func vorbisComment(comments ...string) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, 6)
+	out = append(out, "vendor"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(comments)))
+	for _, c := range comments {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(c)))
+		out = append(out, c...)
+	}
+	return out
+}
+
+// A FLAC metadata block header
+func flacBlock(blockType byte, last bool, body []byte) []byte {
+	if last {
+		blockType |= 0x80
+	}
+	n := len(body)
+	return append([]byte{blockType, byte(n >> 16), byte(n >> 8), byte(n)}, body...)
+}
+
+func TestReadVorbisComments(t *testing.T) {
+	comments := vorbisComment("TITLE=Song", "artist=Band", "ALBUM=Record", "TRACKNUMBER=2/9",
+		"GENRE=Folk", "REPLAYGAIN_ALBUM_GAIN=+1.25 dB", "REPLAYGAIN_ALBUM_PEAK=0.5", "no equals sign")
+	flac := append([]byte("fLaC"), flacBlock(0, false, make([]byte, 34))...)
+	flac = append(flac, flacBlock(4, true, comments)...)
+
+	// An Ogg page holding the identification packet and then the comment packet
+	packet := append([]byte("\x03vorbis"), comments...)
+	ogg := append([]byte("OggS"), make([]byte, 22)...)
+	ogg = append(ogg, 2, 30, byte(len(packet)))
+	ogg = append(ogg, append([]byte("\x01vorbis"), make([]byte, 23)...)...)
+	ogg = append(ogg, packet...)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"flac", flac},
+		{"flac after id3", append(id3Tag(3, -1, id3Frame(3, "TIT2", id3Text(0, "Ignored"))), flac...)},
+		{"ogg", ogg},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := readVorbisComments(bytes.NewReader(test.data), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != "Song" || got.Artist != "Band" || got.Album != "Record" ||
+				got.TrackNumber != 2 || got.Genre != "Folk" {
+				t.Errorf("got %+v", got)
+			}
+			if !got.Gain.HasAlbum || got.Gain.AlbumGain != 1.25 || got.Gain.AlbumPeak != 0.5 || got.Gain.HasTrack {
+				t.Errorf("got gain %+v, want album gain 1.25 and peak 0.5", got.Gain)
+			}
+		})
+	}
+}
+
+func TestReadVorbisCommentsTruncated(t *testing.T) {
+	comments := vorbisComment("TITLE=Song", "ARTIST=Band")
+	// The second comment claims more bytes than are left
+	binary.LittleEndian.PutUint32(comments[len(comments)-15:], 1<<30)
+	got := parseVorbisComment(comments, false)
+	if got.Title != "Song" || got.Artist != "" {
+		t.Errorf("got %+v, want only the title", got)
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+// An endless stream of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,475 @@
+//go:build ignore
+
+// Generate writes the FLAC test files and the PCM each must decode to.
+// Run it from go/music with: go run testdata/flac/generate.go
+//
+// The encoder is deliberately simple but walks every coding choice the
+// decoder has to handle: each frame cycles through the stereo modes and each
+// subframe through verbatim, fixed orders 0 to 4 and LPC, with Rice and
+// escaped residual partitions. Some frames are constant and some have wasted
+// bits. Because FLAC is lossless the expected output is the input signal
+// itself, written as the 32-bit float stereo the player's decoders produce.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+const sampleRate = 44100
+
+type fixture struct {
+	name     string
+	bps      int
+	channels int
+	samples  int
+	variable bool // variable block sizes, an ID3 tag in front and no seek table
+}
+
+var fixtures = []fixture{
+	{name: "stereo16", bps: 16, channels: 2, samples: 5000},
+	{name: "stereo24-variable", bps: 24, channels: 2, samples: 5000, variable: true},
+	{name: "mono8", bps: 8, channels: 1, samples: 3000},
+}
+
+// Fixed-size streams use this block size; the last block is shorter
+const blockSize = 256
+
+// Block sizes variable streams cycle through, including ones coded in 8 and 16 bits
+var variableSizes = []int{256, 192, 100, 1152, 300, 576}
+
+func main() {
+	dir := filepath.Join("testdata", "flac")
+	for _, f := range fixtures {
+		left, right := signal(f)
+		data := encode(f, left, right)
+		if err := os.WriteFile(filepath.Join(dir, f.name+".flac"), data, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(filepath.Join(dir, f.name+".pcm"), pcm(f, left, right), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// Two noisy sines, with a stretch of silence-like constant samples and a
+// stretch where the low bits are always zero
+func signal(f fixture) (left, right []int64) {
+	random := rand.New(rand.NewSource(1))
+	peak := int64(1)<<(f.bps-1) - 1
+	noise := func() int64 { return random.Int63n(peak/25+1) - peak/50 }
+	clamp := func(v int64) int64 { return max(-peak-1, min(peak, v)) }
+	left, right = make([]int64, f.samples), make([]int64, f.samples)
+	for i := range left {
+		a := int64(0.5*float64(peak)*math.Sin(float64(i)*0.05)) + noise()
+		b := int64(0.4*float64(peak)*math.Sin(float64(i)*0.031+1)) + noise()
+		switch {
+		case i >= 2048 && i < 2560:
+			a, b = peak/3, peak/3
+		case i >= 2560 && i < 3072:
+			a, b = a/4*4, b/4*4
+		}
+		left[i], right[i] = clamp(a), clamp(b)
+	}
+	return left, right
+}
+
+func encode(f fixture, left, right []int64) []byte {
+	var frames [][]byte
+	var firsts []int
+	for pos, i := 0, 0; pos < f.samples; i++ {
+		size := blockSize
+		if f.variable {
+			size = variableSizes[i%len(variableSizes)]
+		}
+		size = min(size, f.samples-pos)
+		firsts = append(firsts, pos)
+		frames = append(frames, frame(f, i, pos, left[pos:pos+size], right[pos:pos+size]))
+		pos += size
+	}
+
+	var out bytes.Buffer
+	if f.variable {
+		out.Write([]byte("ID3\x03\x00\x00\x00\x00\x00\x05hello"))
+	}
+	out.WriteString("fLaC")
+
+	info := make([]byte, 34)
+	minSize, maxSize := blockSize, blockSize
+	if f.variable {
+		minSize, maxSize = 16, 1152
+	}
+	binary.BigEndian.PutUint16(info[0:], uint16(minSize))
+	binary.BigEndian.PutUint16(info[2:], uint16(maxSize))
+	packed := uint64(sampleRate)<<44 | uint64(f.channels-1)<<41 | uint64(f.bps-1)<<36 | uint64(f.samples)
+	binary.BigEndian.PutUint64(info[10:], packed)
+	metadata(&out, 0, f.variable, info)
+
+	if !f.variable {
+		// Seek points at every fourth frame, then a placeholder
+		var table []byte
+		offset := 0
+		for i, data := range frames {
+			if i%4 == 0 {
+				table = binary.BigEndian.AppendUint64(table, uint64(firsts[i]))
+				table = binary.BigEndian.AppendUint64(table, uint64(offset))
+				table = binary.BigEndian.AppendUint16(table, blockSize)
+			}
+			offset += len(data)
+		}
+		table = binary.BigEndian.AppendUint64(table, math.MaxUint64)
+		table = append(table, make([]byte, 10)...)
+		metadata(&out, 3, true, table)
+	}
+
+	for _, data := range frames {
+		out.Write(data)
+	}
+	return out.Bytes()
+}
+
+func metadata(out *bytes.Buffer, kind byte, last bool, block []byte) {
+	if last {
+		kind |= 0x80
+	}
+	n := len(block)
+	out.Write([]byte{kind, byte(n >> 16), byte(n >> 8), byte(n)})
+	out.Write(block)
+}
+
+// Encode one frame; index picks the stereo mode and subframe kinds
+func frame(f fixture, index, first int, left, right []int64) []byte {
+	size := len(left)
+	header := []byte{0xFF, 0xF8}
+	if f.variable {
+		header[1] |= 1
+	}
+
+	var sizeCode byte
+	var sizeBytes []byte
+	switch {
+	case size == 192:
+		sizeCode = 1
+	case size == 576 || size == 1152:
+		sizeCode = byte(2 + bits.Len(uint(size/576)) - 1)
+	case size == 256:
+		sizeCode = 8
+	case size <= 256:
+		sizeCode, sizeBytes = 6, []byte{byte(size - 1)}
+	default:
+		sizeCode, sizeBytes = 7, binary.BigEndian.AppendUint16(nil, uint16(size-1))
+	}
+	header = append(header, sizeCode<<4|9) // 9 is 44.1kHz
+
+	assignment := byte(f.channels - 1)
+	if f.channels == 2 {
+		assignment = [4]byte{1, 8, 9, 10}[index%4]
+	}
+	bpsCode := map[int]byte{8: 1, 12: 2, 16: 4, 20: 5, 24: 6}[f.bps]
+	header = append(header, assignment<<4|bpsCode<<1)
+
+	number := index
+	if f.variable {
+		number = first
+	}
+	header = append(header, utf8Number(number)...)
+	header = append(header, sizeBytes...)
+	header = append(header, crc8(header))
+
+	var channels [][]int64
+	var depths []int
+	side := make([]int64, size)
+	for i := range side {
+		if f.channels == 2 {
+			side[i] = left[i] - right[i]
+		}
+	}
+	switch assignment {
+	case 8:
+		channels, depths = [][]int64{left, side}, []int{f.bps, f.bps + 1}
+	case 9:
+		channels, depths = [][]int64{side, right}, []int{f.bps + 1, f.bps}
+	case 10:
+		mid := make([]int64, size)
+		for i := range mid {
+			mid[i] = (left[i] + right[i]) >> 1
+		}
+		channels, depths = [][]int64{mid, side}, []int{f.bps, f.bps + 1}
+	default:
+		channels, depths = [][]int64{left, right}[:f.channels], []int{f.bps, f.bps}
+	}
+
+	w := &bitWriter{}
+	w.bytes = append(w.bytes, header...)
+	for c, samples := range channels {
+		subframe(w, samples, depths[c], index+c, index)
+	}
+	w.align()
+	data := w.bytes
+	return binary.BigEndian.AppendUint16(data, crc16(data))
+}
+
+// Encode one channel of a frame; kind picks how
+func subframe(w *bitWriter, samples []int64, bps, kind, index int) {
+	constant := true
+	for _, s := range samples {
+		constant = constant && s == samples[0]
+	}
+	if constant {
+		w.write(0, 8)
+		w.signed(samples[0], bps)
+		return
+	}
+
+	wasted := 0
+	for wasted < 4 && allMultiples(samples, 1<<(wasted+1)) {
+		wasted++
+	}
+	if wasted > 0 {
+		shifted := make([]int64, len(samples))
+		for i, s := range samples {
+			shifted[i] = s >> wasted
+		}
+		samples, bps = shifted, bps-wasted
+	}
+	head := func(kind uint64) {
+		w.write(kind, 7) // a zero pad bit then the kind
+		if wasted > 0 {
+			w.write(1, 1)
+			w.unary(wasted - 1)
+		} else {
+			w.write(0, 1)
+		}
+	}
+
+	switch kind := kind % 8; {
+	case kind == 0:
+		head(1)
+		for _, s := range samples {
+			w.signed(s, bps)
+		}
+	case kind <= 5:
+		order := kind - 1
+		head(uint64(8 + order))
+		for _, s := range samples[:order] {
+			w.signed(s, bps)
+		}
+		residual := make([]int64, 0, len(samples))
+		for i := order; i < len(samples); i++ {
+			residual = append(residual, samples[i]-fixedPrediction(samples, i, order))
+		}
+		rice(w, residual, order, len(samples), index)
+	default:
+		order, precision, shift := 2, 12, 10
+		coefficients := []float64{1.9, -0.95}
+		if kind == 7 {
+			order = 8
+			coefficients = []float64{1.2, -0.2, 0.1, -0.1, 0.05, -0.05, 0.02, -0.03}
+		}
+		quantised := make([]int64, order)
+		for i, c := range coefficients {
+			quantised[i] = int64(c * float64(int(1)<<shift))
+		}
+		head(uint64(32 + order - 1))
+		for _, s := range samples[:order] {
+			w.signed(s, bps)
+		}
+		w.write(uint64(precision-1), 4)
+		w.signed(int64(shift), 5)
+		for _, c := range quantised {
+			w.signed(c, precision)
+		}
+		residual := make([]int64, 0, len(samples))
+		for i := order; i < len(samples); i++ {
+			var sum int64
+			for j, c := range quantised {
+				sum += c * samples[i-1-j]
+			}
+			residual = append(residual, samples[i]-sum>>shift)
+		}
+		rice(w, residual, order, len(samples), index)
+	}
+}
+
+func allMultiples(samples []int64, n int64) bool {
+	any := false
+	for _, s := range samples {
+		if s%n != 0 {
+			return false
+		}
+		any = any || s != 0
+	}
+	return any
+}
+
+func fixedPrediction(s []int64, i, order int) int64 {
+	switch order {
+	case 1:
+		return s[i-1]
+	case 2:
+		return 2*s[i-1] - s[i-2]
+	case 3:
+		return 3*s[i-1] - 3*s[i-2] + s[i-3]
+	case 4:
+		return 4*s[i-1] - 6*s[i-2] + 4*s[i-3] - s[i-4]
+	}
+	return 0
+}
+
+// Rice-code a residual. The frame index picks the coding method, the
+// partition order and whether the first partition is escaped to raw samples.
+func rice(w *bitWriter, residual []int64, order, size, index int) {
+	method := index % 2
+	paramBits := 4 + method
+	escape := uint64(1)<<paramBits - 1
+	partitionOrder := index % 4
+	for size>>partitionOrder < order || size%(1<<partitionOrder) != 0 {
+		partitionOrder--
+	}
+	w.write(uint64(method), 2)
+	w.write(uint64(partitionOrder), 4)
+
+	per := size >> partitionOrder
+	for p := 0; p < 1<<partitionOrder; p++ {
+		count := per
+		if p == 0 {
+			count -= order
+		}
+		part := residual[:count]
+		residual = residual[count:]
+
+		if index%7 == 3 && p == 0 {
+			width := 1
+			for _, r := range part {
+				width = max(width, bits.Len64(uint64(abs(r)))+1)
+			}
+			w.write(escape, paramBits)
+			w.write(uint64(width), 5)
+			for _, r := range part {
+				w.signed(r, width)
+			}
+			continue
+		}
+
+		var sum float64
+		for _, r := range part {
+			sum += float64(abs(r))
+		}
+		k := int(math.Log2(sum/float64(max(1, len(part))) + 1))
+		k = max(0, min(int(escape)-1, k))
+		w.write(uint64(k), paramBits)
+		for _, r := range part {
+			u := uint64(r) << 1
+			if r < 0 {
+				u = uint64(-r)<<1 - 1
+			}
+			w.unary(int(u >> k))
+			w.write(u&(1<<k-1), k)
+		}
+	}
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// The decoded stream the player should see: 32-bit float stereo, mono on both sides
+func pcm(f fixture, left, right []int64) []byte {
+	if f.channels == 1 {
+		right = left
+	}
+	scale := float32(int64(1) << (f.bps - 1))
+	out := make([]byte, 0, len(left)*8)
+	for i := range left {
+		out = binary.LittleEndian.AppendUint32(out, math.Float32bits(float32(left[i])/scale))
+		out = binary.LittleEndian.AppendUint32(out, math.Float32bits(float32(right[i])/scale))
+	}
+	return out
+}
+
+// Frame and sample numbers are coded like UTF-8, extended to 36 bits
+func utf8Number(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var tail []byte
+	for extra := 1; ; extra++ {
+		tail = append([]byte{0x80 | byte(n&0x3F)}, tail...)
+		n >>= 6
+		if n < 1<<(6-extra) {
+			lead := byte(0xFF << (7 - extra))
+			return append([]byte{lead | byte(n)}, tail...)
+		}
+	}
+}
+
+func crc8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+type bitWriter struct {
+	bytes []byte
+	used  int // bits used in the last byte, 0 when it is full
+}
+
+func (w *bitWriter) write(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.used == 0 {
+			w.bytes = append(w.bytes, 0)
+		}
+		w.bytes[len(w.bytes)-1] |= byte(v>>i&1) << (7 - w.used)
+		w.used = (w.used + 1) % 8
+	}
+}
+
+func (w *bitWriter) signed(v int64, n int) {
+	w.write(uint64(v)&(1<<n-1), n)
+}
+
+func (w *bitWriter) unary(zeros int) {
+	for ; zeros > 0; zeros-- {
+		w.write(0, 1)
+	}
+	w.write(1, 1)
+}
+
+func (w *bitWriter) align() {
+	w.used = 0
+}
@@ -0,0 +1,84 @@
+package main
+
+import "image/color"
+
+// Theme is a named set of UI colors
+type Theme struct {
+	Name        string
+	Background  color.RGBA
+	Highlight   color.RGBA
+	VolumeBar   color.RGBA
+	PlayerBar   color.RGBA
+	Progress    color.RGBA
+	PlayButton  color.RGBA
+	PauseButton color.RGBA
+	Text        color.RGBA
+	ButtonText  color.RGBA
+	ChangeDir   color.RGBA
+}
+
+// Available themes; the first is the default
+var themes = []Theme{
+	{
+		Name:        "dark",
+		Background:  color.RGBA{0x1A, 0x1A, 0x1A, 0xFF},
+		Highlight:   color.RGBA{0x00, 0x88, 0xFF, 0xFF},
+		VolumeBar:   color.RGBA{200, 200, 200, 255},
+		PlayerBar:   color.RGBA{200, 200, 200, 255},
+		Progress:    color.RGBA{100, 200, 200, 255},
+		PlayButton:  color.RGBA{0, 0, 255, 255},
+		PauseButton: color.RGBA{255, 0, 0, 255},
+		Text:        color.RGBA{255, 255, 255, 255},
+		ButtonText:  color.RGBA{255, 255, 255, 255},
+		ChangeDir:   color.RGBA{0, 128, 0, 255},
+	},
+	{
+		Name:        "high-contrast",
+		Background:  color.RGBA{0, 0, 0, 255},
+		Highlight:   color.RGBA{255, 255, 0, 255},
+		VolumeBar:   color.RGBA{255, 255, 255, 255},
+		PlayerBar:   color.RGBA{255, 255, 255, 255},
+		Progress:    color.RGBA{0, 255, 255, 255},
+		PlayButton:  color.RGBA{0, 0, 160, 255},
+		PauseButton: color.RGBA{160, 0, 0, 255},
+		Text:        color.RGBA{255, 255, 255, 255},
+		ButtonText:  color.RGBA{255, 255, 255, 255},
+		ChangeDir:   color.RGBA{0, 100, 0, 255},
+	},
+	{
+		Name:        "high-contrast-light",
+		Background:  color.RGBA{255, 255, 255, 255},
+		Highlight:   color.RGBA{0, 0, 200, 255},
+		VolumeBar:   color.RGBA{0, 0, 0, 255},
+		PlayerBar:   color.RGBA{0, 0, 0, 255},
+		Progress:    color.RGBA{200, 0, 120, 255},
+		PlayButton:  color.RGBA{0, 0, 0, 255},
+		PauseButton: color.RGBA{0, 0, 0, 255},
+		Text:        color.RGBA{0, 0, 0, 255},
+		ButtonText:  color.RGBA{255, 255, 255, 255},
+		ChangeDir:   color.RGBA{0, 0, 0, 255},
+	},
+}
+
+// Current theme
+var theme = themes[0]
+
+// Find a theme by name, falling back to the default
+func themeByName(name string) Theme {
+	for _, t := range themes {
+		if t.Name == name {
+			return t
+		}
+	}
+	return themes[0]
+}
+
+// The theme after the named one, wrapping around
+func nextTheme(name string) Theme {
+	for i, t := range themes {
+		if t.Name == name {
+			return themes[(i+1)%len(themes)]
+		}
+	}
+	return themes[0]
+}
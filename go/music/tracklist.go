@@ -0,0 +1,259 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text"
+)
+
+const scrollBarWidth = 6
+
+// Height of one row in the track list, enough for the current font
+func rowHeight() int {
+	if h := lineHeight() + 4; h > 20 {
+		return h
+	}
+	return 20
+}
+
+// Area below the filter box where the tracks go
+func (p *Player) trackRows() image.Rectangle {
+	list := p.ui.trackList
+	return image.Rect(list.Min.X, list.Min.Y+rowHeight()+4, list.Max.X, list.Max.Y)
+}
+
+func (p *Player) visibleRows() int {
+	return p.trackRows().Dy() / rowHeight()
+}
+
+// Indices of the tracks matching the filter, in list order
+func (p *Player) matchingTracks() []int {
+	filter := strings.ToLower(strings.TrimSpace(string(p.filter)))
+	var matches []int
+	for i, track := range p.tracks {
+		if filter == "" ||
+			strings.Contains(strings.ToLower(track.DisplayName()), filter) ||
+			strings.Contains(strings.ToLower(track.Album), filter) ||
+			strings.Contains(strings.ToLower(track.Name), filter) {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// Keep the scroll position in range and, when the track changes, bring it into view
+func (p *Player) clampScroll(matches []int) {
+	if p.currentTrack != p.followedTrack {
+		p.followedTrack = p.currentTrack
+		for row, i := range matches {
+			if i == p.currentTrack && !p.fromQueue {
+				p.scrollTo(row)
+			}
+		}
+	}
+	if p.listScroll > len(matches)-p.visibleRows() {
+		p.listScroll = len(matches) - p.visibleRows()
+	}
+	if p.listScroll < 0 {
+		p.listScroll = 0
+	}
+}
+
+// Scroll just far enough for a row to be visible
+func (p *Player) scrollTo(row int) {
+	if row < p.listScroll {
+		p.listScroll = row
+	}
+	if row >= p.listScroll+p.visibleRows() {
+		p.listScroll = row - p.visibleRows() + 1
+	}
+}
+
+// Mouse wheel and Page Up/Down scroll the list, clicking a track plays it,
+// Ctrl+clicking selects it for [ ] to move, right-clicking queues it and
+// clicking the filter box starts a search
+func (p *Player) updateTrackList() error {
+	matches := p.matchingTracks()
+	mouseX, mouseY := ebiten.CursorPosition()
+	mouse := image.Pt(mouseX, mouseY)
+
+	if _, wheelY := ebiten.Wheel(); mouse.In(p.ui.trackList) {
+		if wheelY > 0 {
+			p.listScroll -= 3
+		} else if wheelY < 0 {
+			p.listScroll += 3
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageUp) {
+		p.listScroll -= p.visibleRows()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageDown) {
+		p.listScroll += p.visibleRows()
+	}
+	p.clampScroll(matches)
+
+	if inpututil.IsKeyJustPressed(ebiten.KeySlash) {
+		p.focusFilter()
+		// The slash arrives as a typed character this frame; drop it
+		p.runes = ebiten.AppendInputChars(p.runes[:0])
+	}
+
+	// Clicking anywhere else lets [ ] go back to the current track
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		p.listSelected = -1
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) && mouse.In(p.ui.trackList) {
+		rows := p.trackRows()
+		if !mouse.In(rows) {
+			p.focusFilter()
+			return nil
+		}
+		row := p.listScroll + (mouseY-rows.Min.Y)/rowHeight()
+		if row < len(matches) {
+			if ebiten.IsKeyPressed(ebiten.KeyControl) {
+				p.listSelected = matches[row]
+				return nil
+			}
+			return p.engine.PlayTrack(matches[row])
+		}
+	}
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight) && mouse.In(p.trackRows()) {
+		row := p.listScroll + (mouseY-p.trackRows().Min.Y)/rowHeight()
+		if row < len(matches) {
+			i := matches[row]
+			return p.queueTracks(p.tracks[i:i+1], p.tracks[i].DisplayName())
+		}
+	}
+	return nil
+}
+
+// Move the selected track up or down the list
+func (p *Player) moveSelected(delta int) {
+	to := p.listSelected + delta
+	if to < 0 || to >= len(p.tracks) {
+		return
+	}
+	p.engine.MoveTrack(p.listSelected, delta)
+	p.listSelected = to
+}
+
+func (p *Player) focusFilter() {
+	p.filterFocused = true
+	p.selected = 0
+}
+
+// Typing narrows the list; Up/Down pick a match, Enter plays it and Escape
+// clears the filter
+func (p *Player) updateFilter() error {
+	p.runes = ebiten.AppendInputChars(p.runes[:0])
+	if len(p.runes) > 0 {
+		p.filter = append(p.filter, p.runes...)
+		p.selected, p.listScroll = 0, 0
+	}
+	if repeatingKeyPressed(ebiten.KeyBackspace) && len(p.filter) > 0 {
+		p.filter = p.filter[:len(p.filter)-1]
+		p.selected, p.listScroll = 0, 0
+	}
+
+	matches := p.matchingTracks()
+	if repeatingKeyPressed(ebiten.KeyDown) && p.selected < len(matches)-1 {
+		p.selected++
+		p.scrollTo(p.selected)
+	}
+	if repeatingKeyPressed(ebiten.KeyUp) && p.selected > 0 {
+		p.selected--
+		p.scrollTo(p.selected)
+	}
+	p.clampScroll(matches)
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		p.filterFocused = false
+		p.filter = p.filter[:0]
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter) {
+		p.filterFocused = false
+		if p.selected < len(matches) {
+			return p.engine.PlayTrack(matches[p.selected])
+		}
+	}
+
+	// Clicking elsewhere in the list still plays the track under the mouse
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		mouseX, mouseY := ebiten.CursorPosition()
+		rows := p.trackRows()
+		if image.Pt(mouseX, mouseY).In(rows) {
+			p.filterFocused = false
+			row := p.listScroll + (mouseY-rows.Min.Y)/rowHeight()
+			if row < len(matches) {
+				return p.engine.PlayTrack(matches[row])
+			}
+		}
+	}
+	return nil
+}
+
+// Report a key on the first frame and then repeatedly while it is held
+func repeatingKeyPressed(key ebiten.Key) bool {
+	const (
+		delay    = 30
+		interval = 3
+	)
+	d := inpututil.KeyPressDuration(key)
+	return d == 1 || (d >= delay && (d-delay)%interval == 0)
+}
+
+func (p *Player) drawTrackList(screen *ebiten.Image) {
+	face := myFont
+	rh := rowHeight()
+	descent := face.Metrics().Descent.Ceil()
+
+	// Filter box
+	list := p.ui.trackList
+	box := image.Rect(list.Min.X, list.Min.Y, list.Max.X, list.Min.Y+rh)
+	draw.Draw(screen, box, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+	label := "/ Filter: " + string(p.filter)
+	if p.filterFocused {
+		label += "_"
+	}
+	text.Draw(screen, label, face, box.Min.X+5, box.Max.Y-descent-2, theme.Text)
+
+	matches := p.matchingTracks()
+	rows := p.trackRows()
+	if len(matches) == 0 {
+		message := "A to add tracks, or Change Directory to open a folder or playlist"
+		if len(p.filter) > 0 {
+			message = "No tracks match the filter"
+		}
+		text.Draw(screen, message, face, rows.Min.X+10, rows.Min.Y+rh-descent-2, theme.Text)
+		return
+	}
+
+	for row := 0; row < p.visibleRows() && p.listScroll+row < len(matches); row++ {
+		i := matches[p.listScroll+row]
+		top := rows.Min.Y + row*rh
+		if (p.filterFocused && p.listScroll+row == p.selected) || i == p.listSelected {
+			selection := image.Rect(rows.Min.X, top, rows.Max.X-scrollBarWidth-2, top+rh)
+			draw.Draw(screen, selection, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+		}
+		var color color.Color = theme.Text
+		if i == p.currentTrack {
+			color = theme.Highlight
+		}
+		text.Draw(screen, p.tracks[i].DisplayName(), face, rows.Min.X+10, top+rh-descent-2, color)
+	}
+
+	// Scroll bar when the list doesn't fit
+	if len(matches) > p.visibleRows() {
+		bar := image.Rect(rows.Max.X-scrollBarWidth, rows.Min.Y, rows.Max.X, rows.Max.Y)
+		draw.Draw(screen, bar, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+		thumbTop := bar.Min.Y + bar.Dy()*p.listScroll/len(matches)
+		thumbBottom := bar.Min.Y + bar.Dy()*(p.listScroll+p.visibleRows())/len(matches)
+		thumb := image.Rect(bar.Min.X, thumbTop, bar.Max.X, thumbBottom)
+		draw.Draw(screen, thumb, &image.Uniform{C: theme.Progress}, image.Point{}, draw.Src)
+	}
+}
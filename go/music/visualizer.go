@@ -0,0 +1,154 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"math/cmplx"
+
+	"github.com/hajimehoshi/ebiten/v2"
+
+	"music/engine"
+)
+
+const (
+	fftSize       = 2048
+	spectrumBars  = 32
+	minFrequency  = 40
+	maxFrequency  = 16000
+	spectrumFloor = -60  // dB drawn as an empty bar
+	barFalloff    = 0.85 // how much of a bar is left after a frame, so it sinks rather than flickers
+	waveHistory   = 1024 // columns of waveform kept for scrolling
+)
+
+// The spectrum bars and scrolling waveform. Samples come from the engine's tap,
+// so drawing never touches the audio stream itself.
+type visualizer struct {
+	samples []float32
+	window  []float64 // Hann window, to keep the bars from smearing into each other
+	bins    []complex128
+	bars    []float64 // bar heights from 0 to 1
+	wave    []float32 // loudest sample of each frame, newest last
+}
+
+func newVisualizer() *visualizer {
+	v := &visualizer{
+		samples: make([]float32, fftSize),
+		window:  make([]float64, fftSize),
+		bins:    make([]complex128, fftSize),
+		bars:    make([]float64, spectrumBars),
+	}
+	for i := range v.window {
+		v.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(fftSize-1))
+	}
+	return v
+}
+
+// Analyse the latest samples; called once per frame
+func (v *visualizer) update(eng *engine.Engine) {
+	v.samples = eng.Recent(v.samples)
+
+	// Waveform: the peak of the samples that played since the last frame
+	perFrame := eng.SampleRate() / ebiten.TPS()
+	if perFrame > len(v.samples) {
+		perFrame = len(v.samples)
+	}
+	var peak float32
+	for _, s := range v.samples[len(v.samples)-perFrame:] {
+		if s < 0 {
+			s = -s
+		}
+		if s > peak {
+			peak = s
+		}
+	}
+	v.wave = append(v.wave, peak)
+	if len(v.wave) > waveHistory {
+		v.wave = v.wave[len(v.wave)-waveHistory:]
+	}
+
+	// Spectrum: bars spaced evenly in pitch rather than frequency
+	var windowSum float64
+	for i, s := range v.samples {
+		v.bins[i] = complex(float64(s)*v.window[i], 0)
+		windowSum += v.window[i]
+	}
+	fft(v.bins)
+	binWidth := float64(eng.SampleRate()) / fftSize
+	for b := range v.bars {
+		low := minFrequency * math.Pow(maxFrequency/minFrequency, float64(b)/spectrumBars)
+		high := minFrequency * math.Pow(maxFrequency/minFrequency, float64(b+1)/spectrumBars)
+		first, last := int(low/binWidth), int(high/binWidth)
+		if last <= first {
+			last = first + 1
+		}
+		var magnitude float64
+		for k := first; k < last && k < fftSize/2; k++ {
+			magnitude = math.Max(magnitude, cmplx.Abs(v.bins[k]))
+		}
+		// Scale so a full-scale sine reads 0 dB
+		level := 0.0
+		if amplitude := 2 * magnitude / windowSum; amplitude > 0 {
+			level = (20*math.Log10(amplitude) - spectrumFloor) / -spectrumFloor
+		}
+		level = math.Max(0, math.Min(level, 1))
+		v.bars[b] = math.Max(level, v.bars[b]*barFalloff)
+	}
+}
+
+func (v *visualizer) drawSpectrum(screen *ebiten.Image, area image.Rectangle) {
+	draw.Draw(screen, area, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+	barWidth := area.Dx() / spectrumBars
+	if barWidth < 2 {
+		return
+	}
+	for b, level := range v.bars {
+		x := area.Min.X + b*barWidth
+		top := area.Max.Y - int(level*float64(area.Dy()))
+		bar := image.Rect(x+1, top, x+barWidth-1, area.Max.Y)
+		draw.Draw(screen, bar, &image.Uniform{C: theme.Progress}, image.Point{}, draw.Src)
+	}
+}
+
+// Newest column on the right, scrolling left as the track plays
+func (v *visualizer) drawWaveform(screen *ebiten.Image, area image.Rectangle) {
+	draw.Draw(screen, area, &image.Uniform{C: theme.PlayerBar}, image.Point{}, draw.Src)
+	middle := area.Min.Y + area.Dy()/2
+	columns := v.wave
+	if len(columns) > area.Dx() {
+		columns = columns[len(columns)-area.Dx():]
+	}
+	x := area.Max.X - len(columns)
+	for i, peak := range columns {
+		half := int(math.Min(float64(peak), 1) * float64(area.Dy()/2))
+		line := image.Rect(x+i, middle-half, x+i+1, middle+half+1)
+		draw.Draw(screen, line, &image.Uniform{C: theme.Highlight}, image.Point{}, draw.Src)
+	}
+}
+
+// In-place radix-2 FFT; len(x) must be a power of two
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], x[start+k+size/2]*w
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
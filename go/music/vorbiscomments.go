@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Read the Vorbis comments of a FLAC file, or of an Ogg Vorbis file's comment
+// header. Keys are the usual TITLE, ARTIST, ALBUM, TRACKNUMBER and
+// REPLAYGAIN_* ones.
+func readVorbisComments(file io.ReadSeeker, withArtwork bool) (Tags, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return Tags{}, err
+	}
+	r := bufio.NewReader(file)
+
+	// Some taggers put an ID3 tag in front of the FLAC stream
+	if header, err := r.Peek(10); err == nil && string(header[:3]) == "ID3" {
+		size := synchsafe(header[6:10]) + 10
+		if header[5]&0x10 != 0 {
+			size += 10 // footer
+		}
+		if _, err := r.Discard(size); err != nil {
+			return Tags{}, errNoTags
+		}
+	}
+
+	magic, err := r.Peek(4)
+	if err != nil {
+		return Tags{}, errNoTags
+	}
+	switch string(magic) {
+	case "fLaC":
+		r.Discard(4)
+		return readFLACComments(r, withArtwork)
+	case "OggS":
+		return readOggComments(r, withArtwork)
+	}
+	return Tags{}, errNoTags
+}
+
+// FLAC keeps comments and pictures in metadata blocks ahead of the audio
+func readFLACComments(r *bufio.Reader, withArtwork bool) (Tags, error) {
+	var tags Tags
+	found := false
+	for last := false; !last; {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return tags, err
+		}
+		last = header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+
+		if blockType != 4 && (blockType != 6 || !withArtwork || tags.Artwork != nil) {
+			if _, err := r.Discard(length); err != nil {
+				return tags, err
+			}
+			continue
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return tags, err
+		}
+		if blockType == 4 {
+			tags.fillFrom(parseVorbisComment(block, withArtwork))
+			found = true
+		} else {
+			tags.Artwork = flacPicture(block)
+		}
+	}
+	if !found {
+		return tags, errNoTags
+	}
+	return tags, nil
+}
+
+// Maximum an Ogg file's header packets are read to, so a broken file can't make us read it all
+const maxOggHeaders = 16 << 20
+
+// The comments are the second packet of the first logical stream
+func readOggComments(r *bufio.Reader, withArtwork bool) (Tags, error) {
+	var packet []byte
+	packets := 0
+	total := 0
+	for packets < 2 {
+		header := make([]byte, 27)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return Tags{}, err
+		}
+		if string(header[:4]) != "OggS" {
+			return Tags{}, errors.New("ogg: lost page sync")
+		}
+		lacing := make([]byte, header[26])
+		if _, err := io.ReadFull(r, lacing); err != nil {
+			return Tags{}, err
+		}
+		for _, size := range lacing {
+			segment := make([]byte, size)
+			if _, err := io.ReadFull(r, segment); err != nil {
+				return Tags{}, err
+			}
+			if total += int(size); total > maxOggHeaders {
+				return Tags{}, errNoTags
+			}
+			if packets == 1 {
+				packet = append(packet, segment...)
+			}
+			// A segment shorter than 255 bytes ends its packet
+			if size < 255 {
+				packets++
+				if packets == 2 {
+					break
+				}
+			}
+		}
+	}
+	if len(packet) < 7 || string(packet[:7]) != "\x03vorbis" {
+		return Tags{}, errNoTags
+	}
+	return parseVorbisComment(packet[7:], withArtwork), nil
+}
+
+// Parse a comment block: a vendor string then a count of KEY=value strings,
+// all lengths little-endian
+func parseVorbisComment(data []byte, withArtwork bool) Tags {
+	var tags Tags
+	next := func() (string, bool) {
+		if len(data) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			return "", false
+		}
+		s := string(data[4 : 4+n])
+		data = data[4+n:]
+		return s, true
+	}
+
+	if _, ok := next(); !ok || len(data) < 4 {
+		return tags
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	for ; count > 0; count-- {
+		comment, ok := next()
+		if !ok {
+			break
+		}
+		key, value, ok := strings.Cut(comment, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			tags.Title = value
+		case "ARTIST":
+			tags.Artist = value
+		case "ALBUM":
+			tags.Album = value
+		case "GENRE":
+			tags.Genre = value
+		case "TRACKNUMBER":
+			number, _, _ := strings.Cut(value, "/")
+			tags.TrackNumber, _ = strconv.Atoi(strings.TrimSpace(number))
+		case "METADATA_BLOCK_PICTURE":
+			// Ogg files carry a FLAC picture block in base64
+			if withArtwork && tags.Artwork == nil {
+				if block, err := base64.StdEncoding.DecodeString(value); err == nil {
+					tags.Artwork = flacPicture(block)
+				}
+			}
+		default:
+			tags.setReplayGain(key, value)
+		}
+	}
+	return tags
+}
+
+// Image bytes from a FLAC picture block: type, MIME type, description,
+// dimensions and then the data, all lengths big-endian
+func flacPicture(block []byte) []byte {
+	skip := func(n int) bool {
+		if n > len(block) {
+			return false
+		}
+		block = block[n:]
+		return true
+	}
+	length := func() int {
+		if len(block) < 4 {
+			return len(block) + 1
+		}
+		n := binary.BigEndian.Uint32(block)
+		block = block[4:]
+		if uint64(n) > uint64(len(block)) {
+			return len(block) + 1
+		}
+		return int(n)
+	}
+
+	if !skip(4) || !skip(length()) || !skip(length()) || !skip(16) {
+		return nil
+	}
+	n := length()
+	if n > len(block) || n == 0 {
+		return nil
+	}
+	return block[:n]
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Adventure holds the world and the player's progress. The terminal loop and
+// the ebiten front-end both drive it through describe and handle.
+type Adventure struct {
+	rooms       []Room
+	npcs        []NPC
+	items       []Item
+	config      GameConfig
+	inventory   map[int]Item // Simple inventory system
+	stats       *Stats
+	currentRoom *Room
+	over        bool
+}
+
+func newAdventure(rooms []Room, npcs []NPC, items []Item, config GameConfig) *Adventure {
+	a := &Adventure{
+		rooms:       rooms,
+		npcs:        npcs,
+		items:       items,
+		config:      config,
+		inventory:   make(map[int]Item),
+		stats:       newStats(),
+		currentRoom: &rooms[0], // Start in the first room unless the game config says otherwise
+	}
+	if room := findRoom(rooms, config.StartRoom); room != nil {
+		a.currentRoom = room
+	}
+	a.stats.Visited[a.currentRoom.ID] = true
+	return a
+}
+
+// Exits of the current room as display lines, sorted so they don't jump around
+func (a *Adventure) exits() []string {
+	directions := make([]string, 0, len(a.currentRoom.Exits))
+	for direction := range a.currentRoom.Exits {
+		directions = append(directions, direction)
+	}
+	sort.Strings(directions)
+
+	var lines []string
+	for _, direction := range directions {
+		lines = append(lines, msg("exit", localDirection(direction), a.currentRoom.Exits[direction]))
+	}
+	return lines
+}
+
+// NPCs in the current room
+func (a *Adventure) npcsHere() []NPC {
+	var here []NPC
+	for _, npcID := range a.currentRoom.NPCs {
+		for _, npc := range a.npcs {
+			if npc.ID == npcID {
+				here = append(here, npc)
+			}
+		}
+	}
+	return here
+}
+
+// Items lying in the current room
+func (a *Adventure) itemsHere() []Item {
+	var here []Item
+	for _, itemID := range a.currentRoom.Items {
+		for _, item := range a.items {
+			if item.ID == itemID {
+				here = append(here, item)
+			}
+		}
+	}
+	return here
+}
+
+// Full description of the current room, as printed by the terminal loop
+func (a *Adventure) describe() []string {
+	lines := []string{
+		msg("you_are_in", a.currentRoom.LocalName()),
+		a.currentRoom.LocalDescription(),
+		msg("exits"),
+	}
+	lines = append(lines, a.exits()...)
+
+	// Display NPCs
+	lines = append(lines, msg("you_see"))
+	for _, npc := range a.npcsHere() {
+		lines = append(lines, msg("list_entry", npc.LocalName(), npc.LocalDescription()))
+	}
+
+	// Display items
+	lines = append(lines, msg("items_available"))
+	for _, item := range a.itemsHere() {
+		lines = append(lines, msg("list_entry", item.LocalName(), item.LocalDescription()))
+	}
+	return lines
+}
+
+// End the game early and return the summary
+func (a *Adventure) quit() []string {
+	a.over = true
+	return a.stats.summary(nil, a.config, a.rooms, a.items)
+}
+
+// Run one player command and return what should be shown in response
+func (a *Adventure) handle(input string) []string {
+	if a.over {
+		return nil
+	}
+
+	input = strings.TrimSpace(input)
+	verb, target, _ := strings.Cut(input, " ")
+	target = strings.TrimSpace(target)
+
+	var lines []string
+	acted := false // only commands that do something count as moves
+
+	// Handle movement
+	if exitRoomID, exists := a.currentRoom.Exits[exitDirection(input, a.currentRoom.Exits)]; exists {
+		if room := findRoom(a.rooms, exitRoomID); room != nil {
+			a.currentRoom = room
+			acted = true
+			if !a.stats.Visited[room.ID] {
+				a.stats.Visited[room.ID] = true
+				a.stats.Score += a.config.Scoring.DiscoverRoom
+			}
+		}
+	} else if input == msg("cmd_quit") {
+		return a.quit()
+	} else if input == msg("cmd_score") {
+		lines = append(lines, msg("stat_score", a.stats.Score))
+	} else if input == msg("cmd_talk") {
+		for _, npc := range a.npcsHere() {
+			lines = append(lines, npc.LocalDialogue())
+			acted = true
+		}
+	} else if verb == msg("cmd_take") && target != "" {
+		var line string
+		line, acted = a.take(target)
+		lines = append(lines, line)
+	} else if verb == msg("cmd_attack") && target != "" {
+		var line string
+		line, acted = a.attack(target)
+		lines = append(lines, line)
+	} else {
+		lines = append(lines, msg("cant_do_that"))
+	}
+	if acted {
+		a.stats.Moves++
+	}
+
+	// Quests and end conditions
+	lines = append(lines, a.stats.checkQuests(a.config.Quests, a.currentRoom.ID, a.inventory)...)
+	if ending := checkEndings(a.config.Endings, a.currentRoom.ID, a.inventory, a.stats.Defeated); ending != nil {
+		a.over = true
+		lines = append(lines, "")
+		lines = append(lines, a.stats.summary(ending, a.config, a.rooms, a.items)...)
+	}
+	return lines
+}
+
+// Pick up an item from the current room by name, reporting whether there was one
+func (a *Adventure) take(target string) (string, bool) {
+	for _, item := range a.itemsHere() {
+		if !strings.EqualFold(target, item.LocalName()) {
+			continue
+		}
+		a.inventory[item.ID] = item
+		a.currentRoom.Items = removeItem(a.currentRoom.Items, item.ID) // Remove item from room
+		if !a.stats.Taken[item.ID] {
+			a.stats.Taken[item.ID] = true
+			a.stats.Score += a.config.Scoring.TakeItem
+		}
+		return msg("taken", strings.ToLower(item.LocalName())), true
+	}
+	return msg("no_such_item", target), false
+}
+
+// Attack an NPC in the current room by name, reporting whether a blow was struck
+func (a *Adventure) attack(target string) (string, bool) {
+	for _, npc := range a.npcsHere() {
+		if !strings.EqualFold(target, npc.LocalName()) {
+			continue
+		}
+		if _, armed := a.inventory[npc.Weakness]; !npc.Hostile {
+			return msg("not_hostile", npc.LocalName()), false
+		} else if npc.Weakness != 0 && !armed {
+			return msg("attack_failed", npc.LocalName()), true
+		}
+		a.stats.Defeated[npc.ID] = true
+		a.stats.Score += a.config.Scoring.DefeatNPC
+		a.currentRoom.NPCs = removeItem(a.currentRoom.NPCs, npc.ID) // Defeated NPCs leave the room
+		return msg("defeated", npc.LocalName()), true
+	}
+	return msg("no_such_npc", target), false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHandle(t *testing.T) {
+	scoring := Scoring{DiscoverRoom: 5, TakeItem: 10, DefeatNPC: 25}
+	armed := Quest{ID: 1, Name: "Armed", Points: 7, When: Condition{Items: []int{10}}}
+	tests := []struct {
+		name     string
+		config   func(config *GameConfig)
+		commands []string
+		over     string // first line of the summary, empty if the game goes on
+		score    int
+		moves    int
+	}{
+		{
+			"win",
+			func(config *GameConfig) {
+				config.Scoring = scoring
+				config.Quests = []Quest{armed}
+			},
+			// The first attack fails without the stick but is still a move
+			[]string{"north", "attack rat", "south", "take stick", "north", "attack rat"},
+			"=== You win! ===",
+			5 + 10 + 7 + 25,
+			6,
+		},
+		{
+			"armed so not lost",
+			func(config *GameConfig) {
+				config.Endings = append([]Ending{{Outcome: "lose", When: Condition{Room: 2, Lacking: []int{10}}}}, config.Endings...)
+			},
+			[]string{"take stick", "south", "drop stick", "north"},
+			"",
+			0,
+			2,
+		},
+		{
+			"lose unarmed",
+			func(config *GameConfig) {
+				config.Endings = append([]Ending{{Outcome: "lose", When: Condition{Room: 2, Lacking: []int{10}}}}, config.Endings...)
+			},
+			[]string{"north"},
+			"=== Game over ===",
+			0,
+			1,
+		},
+		{
+			// None of these do anything, so none is a move
+			"quit",
+			func(config *GameConfig) { config.Scoring = scoring },
+			[]string{"", "dance", "score", "talk", "take sword", "attack ghost", "south", "quit"},
+			"=== Thanks for playing ===",
+			0,
+			0,
+		},
+		{
+			"after the end",
+			func(*GameConfig) {},
+			[]string{"quit", "north", "take stick"},
+			"=== Thanks for playing ===",
+			0,
+			0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rooms, npcs, items, config := testWorld()
+			test.config(&config)
+			adventure := newAdventure(rooms, npcs, items, config)
+
+			var last []string
+			for _, command := range test.commands {
+				if lines := adventure.handle(command); lines != nil {
+					last = lines
+				}
+			}
+
+			if adventure.over != (test.over != "") {
+				t.Fatalf("over = %v after %q, last output %q", adventure.over, test.commands, last)
+			}
+			if test.over != "" {
+				at := slices.Index(last, test.over)
+				if at < 0 {
+					t.Fatalf("output %q has no %q", last, test.over)
+				}
+				summary := last[at:]
+				if !slices.Contains(summary, msg("stat_score", test.score)) || !slices.Contains(summary, msg("stat_moves", test.moves)) {
+					t.Errorf("summary %q, want score %d and %d moves", summary, test.score, test.moves)
+				}
+			}
+			if adventure.stats.Score != test.score || adventure.stats.Moves != test.moves {
+				t.Errorf("score %d and %d moves, want %d and %d", adventure.stats.Score, adventure.stats.Moves, test.score, test.moves)
+			}
+		})
+	}
+}
+
+func TestHandleQuest(t *testing.T) {
+	rooms, npcs, items, config := testWorld()
+	config.Quests = []Quest{{ID: 1, Name: "Armed", Points: 7, When: Condition{Items: []int{10}}}}
+	adventure := newAdventure(rooms, npcs, items, config)
+
+	lines := adventure.handle("take stick")
+	want := []string{msg("taken", "stick"), msg("quest_complete", "Armed", 7)}
+	if !slices.Equal(lines, want) {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+	// A quest is only completed once
+	adventure.handle("north")
+	if lines := adventure.handle("south"); len(lines) != 0 || adventure.stats.Score != 7 || len(adventure.stats.Completed) != 1 {
+		t.Errorf("got %q and score %d after the quest was done", lines, adventure.stats.Score)
+	}
+}
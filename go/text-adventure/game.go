@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Condition describes a game state; every field that is set must hold for it to be met
+type Condition struct {
+	Room     int   `json:"room" yaml:"room" toml:"room"`             // player is in this room
+	Items    []int `json:"items" yaml:"items" toml:"items"`          // player holds all of these items
+	Lacking  []int `json:"lacking" yaml:"lacking" toml:"lacking"`    // player holds none of these items
+	Defeated []int `json:"defeated" yaml:"defeated" toml:"defeated"` // all of these NPCs have been defeated
+}
+
+// Quest awards points the first time its condition is met
+type Quest struct {
+	ID          int       `json:"id" yaml:"id" toml:"id"`
+	Name        string    `json:"name" yaml:"name" toml:"name"`
+	Description string    `json:"description" yaml:"description" toml:"description"`
+	Points      int       `json:"points" yaml:"points" toml:"points"`
+	When        Condition `json:"when" yaml:"when" toml:"when"`
+
+	Translations map[string]Translation `json:"translations,omitempty" yaml:"translations,omitempty" toml:"translations,omitempty"`
+}
+
+// Ending finishes the game with a win or a loss when its condition is met
+type Ending struct {
+	Outcome     string    `json:"outcome" yaml:"outcome" toml:"outcome"` // "win" or "lose"
+	Description string    `json:"description" yaml:"description" toml:"description"`
+	When        Condition `json:"when" yaml:"when" toml:"when"`
+
+	Translations map[string]Translation `json:"translations,omitempty" yaml:"translations,omitempty" toml:"translations,omitempty"`
+}
+
+// Points awarded for discoveries
+type Scoring struct {
+	DiscoverRoom int `json:"discover_room" yaml:"discover_room" toml:"discover_room"`
+	TakeItem     int `json:"take_item" yaml:"take_item" toml:"take_item"`
+	DefeatNPC    int `json:"defeat_npc" yaml:"defeat_npc" toml:"defeat_npc"`
+}
+
+type GameConfig struct {
+	StartRoom int      `json:"start_room" yaml:"start_room" toml:"start_room"`
+	Scoring   Scoring  `json:"scoring" yaml:"scoring" toml:"scoring"`
+	Quests    []Quest  `json:"quests" yaml:"quests" toml:"quests"`
+	Endings   []Ending `json:"endings" yaml:"endings" toml:"endings"`
+}
+
+// Stats tracks the player's progress for scoring and the end-of-game summary
+type Stats struct {
+	Score     int
+	Moves     int
+	Visited   map[int]bool
+	Taken     map[int]bool
+	Defeated  map[int]bool
+	Completed map[int]bool
+}
+
+// Load the game file. Worlds written before it existed have none, and play
+// as before: no quests, no endings and the first room as the start.
+func loadGameConfig(filename string) (GameConfig, error) {
+	var config GameConfig
+	err := decodeFile(filename, "", &config)
+	if errors.Is(err, fs.ErrNotExist) {
+		return GameConfig{}, nil
+	}
+	return config, err
+}
+
+func newStats() *Stats {
+	return &Stats{
+		Visited:   make(map[int]bool),
+		Taken:     make(map[int]bool),
+		Defeated:  make(map[int]bool),
+		Completed: make(map[int]bool),
+	}
+}
+
+// An empty condition sets nothing, so it would be met on the very first turn
+func (c Condition) empty() bool {
+	return c.Room == 0 && len(c.Items) == 0 && len(c.Lacking) == 0 && len(c.Defeated) == 0
+}
+
+// Check whether a condition holds for the current room, inventory and defeated NPCs
+func (c Condition) met(roomID int, inventory map[int]Item, defeated map[int]bool) bool {
+	if c.Room != 0 && c.Room != roomID {
+		return false
+	}
+	for _, itemID := range c.Items {
+		if _, held := inventory[itemID]; !held {
+			return false
+		}
+	}
+	for _, itemID := range c.Lacking {
+		if _, held := inventory[itemID]; held {
+			return false
+		}
+	}
+	for _, npcID := range c.Defeated {
+		if !defeated[npcID] {
+			return false
+		}
+	}
+	return true
+}
+
+func (q Quest) LocalName() string {
+	return localize(q.Translations, func(t Translation) string { return t.Name }, q.Name)
+}
+
+func (e Ending) LocalDescription() string {
+	return localize(e.Translations, func(t Translation) string { return t.Description }, e.Description)
+}
+
+// Award points for any quests completed since the last check and report them
+func (s *Stats) checkQuests(quests []Quest, roomID int, inventory map[int]Item) []string {
+	var lines []string
+	for _, quest := range quests {
+		if s.Completed[quest.ID] || !quest.When.met(roomID, inventory, s.Defeated) {
+			continue
+		}
+		s.Completed[quest.ID] = true
+		s.Score += quest.Points
+		lines = append(lines, msg("quest_complete", quest.LocalName(), quest.Points))
+	}
+	return lines
+}
+
+// Find the first ending whose condition is met, if any
+func checkEndings(endings []Ending, roomID int, inventory map[int]Item, defeated map[int]bool) *Ending {
+	for i := range endings {
+		if endings[i].When.met(roomID, inventory, defeated) {
+			return &endings[i]
+		}
+	}
+	return nil
+}
+
+// Build the end-of-game summary screen; ending is nil when the player quit
+func (s *Stats) summary(ending *Ending, config GameConfig, rooms []Room, items []Item) []string {
+	var lines []string
+	switch {
+	case ending == nil:
+		lines = append(lines, msg("summary_quit"))
+	case ending.Outcome == "win":
+		lines = append(lines, msg("summary_win"), ending.LocalDescription())
+	default:
+		lines = append(lines, msg("summary_lose"), ending.LocalDescription())
+	}
+	lines = append(lines,
+		msg("stat_score", s.Score),
+		msg("stat_moves", s.Moves),
+		msg("stat_rooms", len(s.Visited), len(rooms)),
+		msg("stat_items", len(s.Taken), len(items)),
+		msg("stat_defeated", len(s.Defeated)),
+		msg("stat_quests", len(s.Completed), len(config.Quests)),
+	)
+	return lines
+}
+
+// Print lines to the terminal
+func printLines(lines []string) {
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
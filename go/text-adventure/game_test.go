@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadGameConfigMissingFile(t *testing.T) {
+	config, err := loadGameConfig(filepath.Join(t.TempDir(), "game.json"))
+	if err != nil {
+		t.Fatalf("missing game file: %v", err)
+	}
+	if config.StartRoom != 0 || len(config.Quests) != 0 || len(config.Endings) != 0 {
+		t.Errorf("missing game file gave %+v, want a zero config", config)
+	}
+}
+
+func TestConditionEmpty(t *testing.T) {
+	tests := []struct {
+		when  Condition
+		empty bool
+	}{
+		{Condition{}, true},
+		{Condition{Items: []int{}}, true},
+		{Condition{Room: 2}, false},
+		{Condition{Items: []int{1}}, false},
+		{Condition{Lacking: []int{1}}, false},
+		{Condition{Defeated: []int{3}}, false},
+	}
+	for _, test := range tests {
+		if got := test.when.empty(); got != test.empty {
+			t.Errorf("%+v empty() = %v, want %v", test.when, got, test.empty)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/hajimehoshi/bitmapfont/v3"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/text/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+const (
+	screenWidth  = 800
+	screenHeight = 600
+	padding      = 8
+	lineHeight   = 16
+	sideWidth    = 260 // width of the exits/people/items column
+	logTop       = 220 // y where the log panel starts
+	inputHeight  = 28
+
+	mainWidth   = screenWidth - sideWidth - 3*padding // width of the room and log panels
+	logHeight   = screenHeight - logTop - inputHeight - 2*padding
+	titleHeight = padding/2 + lineHeight + padding/2               // a panel's title, above its content
+	logRows     = (logHeight - titleHeight - padding) / lineHeight // log lines that fit in the panel
+)
+
+// Colors
+var (
+	backgroundColor = color.RGBA{0x1A, 0x1A, 0x1A, 0xFF}
+	panelColor      = color.RGBA{0x26, 0x26, 0x2E, 0xFF}
+	titleColor      = color.RGBA{0x00, 0x88, 0xFF, 0xFF}
+	textColor       = color.RGBA{255, 255, 255, 255}
+	inputColor      = color.RGBA{0x33, 0x33, 0x40, 0xFF}
+)
+
+var fontFace = text.NewGoXFace(bitmapfont.Face)
+
+// AdventureUI is the ebiten front-end; all game rules live in Adventure
+type AdventureUI struct {
+	adventure *Adventure
+	log       []string
+	scroll    int // lines scrolled up from the bottom of the log
+	input     []rune
+	runes     []rune
+}
+
+func runGUI(adventure *Adventure) error {
+	ui := &AdventureUI{
+		adventure: adventure,
+		log:       []string{msg("you_are_in", adventure.currentRoom.LocalName())},
+	}
+	ebiten.SetWindowSize(screenWidth, screenHeight)
+	ebiten.SetWindowTitle(msg("you_are_in", adventure.currentRoom.LocalName()))
+	return ebiten.RunGame(ui)
+}
+
+func (ui *AdventureUI) Update() error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		return ebiten.Termination
+	}
+
+	// Scrolling log
+	_, wheelY := ebiten.Wheel()
+	if wheelY > 0 || inpututil.IsKeyJustPressed(ebiten.KeyPageUp) {
+		ui.scroll++
+	}
+	if wheelY < 0 || inpututil.IsKeyJustPressed(ebiten.KeyPageDown) {
+		ui.scroll--
+	}
+	// Entries wrap, so it's the wrapped lines that scroll, up to the oldest at the top
+	ui.scroll = max(0, min(ui.scroll, len(ui.wrappedLog())-logRows))
+
+	if ui.adventure.over {
+		return nil
+	}
+
+	// Input line
+	ui.runes = ebiten.AppendInputChars(ui.runes[:0])
+	ui.input = append(ui.input, ui.runes...)
+	if repeatingKeyPressed(ebiten.KeyBackspace) && len(ui.input) > 0 {
+		ui.input = ui.input[:len(ui.input)-1]
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadEnter) {
+		command := strings.TrimSpace(string(ui.input))
+		ui.input = ui.input[:0]
+		if command != "" {
+			previous := ui.adventure.currentRoom
+			ui.log = append(ui.log, "> "+command)
+			ui.log = append(ui.log, ui.adventure.handle(command)...)
+			if ui.adventure.currentRoom != previous {
+				ui.log = append(ui.log, msg("you_are_in", ui.adventure.currentRoom.LocalName()))
+				ebiten.SetWindowTitle(msg("you_are_in", ui.adventure.currentRoom.LocalName()))
+			}
+			ui.scroll = 0
+		}
+	}
+	return nil
+}
+
+// Report a key on the first frame and then repeatedly while it is held
+func repeatingKeyPressed(key ebiten.Key) bool {
+	const (
+		delay    = 30
+		interval = 3
+	)
+	d := inpututil.KeyPressDuration(key)
+	return d == 1 || (d >= delay && (d-delay)%interval == 0)
+}
+
+func (ui *AdventureUI) Draw(screen *ebiten.Image) {
+	screen.Fill(backgroundColor)
+	room := ui.adventure.currentRoom
+
+	// Room panel
+	panel, y := ui.drawPanel(screen, padding, padding, mainWidth, logTop-2*padding, msg("panel_room"))
+	drawText(panel, room.LocalName(), padding*2, y, titleColor)
+	for _, line := range wrapText(room.LocalDescription(), mainWidth-2*padding) {
+		y += lineHeight
+		drawText(panel, line, padding*2, y, textColor)
+	}
+
+	// Exits, people and items down the right-hand side
+	sideX := screenWidth - sideWidth - padding
+	panelHeight := (screenHeight - 4*padding) / 3
+	panel, y = ui.drawPanel(screen, sideX, padding, sideWidth, panelHeight, msg("panel_exits"))
+	for _, line := range ui.adventure.exits() {
+		drawText(panel, line, sideX+padding, y, textColor)
+		y += lineHeight
+	}
+	panel, y = ui.drawPanel(screen, sideX, 2*padding+panelHeight, sideWidth, panelHeight, msg("panel_npcs"))
+	for _, npc := range ui.adventure.npcsHere() {
+		drawText(panel, "- "+npc.LocalName(), sideX+padding, y, textColor)
+		y += lineHeight
+	}
+	panel, y = ui.drawPanel(screen, sideX, 3*padding+2*panelHeight, sideWidth, panelHeight, msg("panel_items"))
+	for _, item := range ui.adventure.itemsHere() {
+		drawText(panel, "- "+item.LocalName(), sideX+padding, y, textColor)
+		y += lineHeight
+	}
+
+	// Scrolling log, newest at the bottom
+	panel, top := ui.drawPanel(screen, padding, logTop, mainWidth, logHeight, msg("panel_log"))
+	wrapped := ui.wrappedLog()
+	end := max(0, len(wrapped)-ui.scroll)
+	y = logTop + logHeight - padding - lineHeight
+	for i := end - 1; i >= 0 && y >= top; i-- {
+		drawText(panel, wrapped[i], padding*2, y, textColor)
+		y -= lineHeight
+	}
+
+	// Input line
+	inputY := screenHeight - padding - inputHeight
+	vector.DrawFilledRect(screen, padding, float32(inputY), float32(mainWidth), inputHeight, inputColor, false)
+	prompt := msg("prompt") + string(ui.input)
+	if !ui.adventure.over {
+		prompt += "_"
+	}
+	drawText(screen, prompt, padding*2, inputY+(inputHeight-lineHeight)/2, textColor)
+}
+
+// Draw a titled panel and return it to draw the content into, so nothing
+// spills out of it, and the y where its content starts
+func (ui *AdventureUI) drawPanel(screen *ebiten.Image, x, y, w, h int, title string) (*ebiten.Image, int) {
+	panel := screen.SubImage(image.Rect(x, y, x+w, y+h)).(*ebiten.Image)
+	vector.DrawFilledRect(panel, float32(x), float32(y), float32(w), float32(h), panelColor, false)
+	drawText(panel, title, x+padding, y+padding/2, titleColor)
+	return panel, y + titleHeight
+}
+
+// The log's entries broken into lines that fit the log panel
+func (ui *AdventureUI) wrappedLog() []string {
+	var wrapped []string
+	for _, entry := range ui.log {
+		wrapped = append(wrapped, wrapText(entry, mainWidth-2*padding)...)
+	}
+	return wrapped
+}
+
+func drawText(screen *ebiten.Image, s string, x, y int, clr color.Color) {
+	op := &text.DrawOptions{}
+	op.GeoM.Translate(float64(x), float64(y))
+	op.ColorScale.ScaleWithColor(clr)
+	text.Draw(screen, s, fontFace, op)
+}
+
+// Break text into lines that fit within width pixels
+func wrapText(s string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if line != "" && text.Advance(candidate, fontFace) > float64(width) {
+			lines = append(lines, line)
+			candidate = word
+		}
+		line = candidate
+	}
+	return append(lines, line)
+}
+
+func (ui *AdventureUI) Layout(_, _ int) (int, int) {
+	return screenWidth, screenHeight
+}
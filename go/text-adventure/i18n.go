@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directory holding one message catalog per locale, e.g. locales/es.json
+const localesDir = "locales"
+
+// Translation holds the localized text for a single room, NPC or item
+type Translation struct {
+	Name        string `json:"name" yaml:"name" toml:"name"`
+	Description string `json:"description" yaml:"description" toml:"description"`
+	Dialogue    string `json:"dialogue" yaml:"dialogue" toml:"dialogue"`
+}
+
+// English messages, used as the fallback for keys missing from a catalog
+var defaultMessages = map[string]string{
+	"you_are_in":      "You are in %s.",
+	"exits":           "Exits:",
+	"exit":            "- %s to %d",
+	"list_entry":      "- %s: %s",
+	"you_see":         "You see:",
+	"items_available": "Items available:",
+	"prompt":          "What do you want to do? ",
+	"cant_do_that":    "You can't go that way or perform that action.",
+	"taken":           "You have taken the %s.",
+	"no_such_item":    "There is no %s here.",
+	"no_such_npc":     "There is nobody called %s here.",
+	"not_hostile":     "You can't bring yourself to attack the %s.",
+	"attack_failed":   "The %s shrugs off your attack. You need something stronger.",
+	"defeated":        "You have defeated the %s!",
+	"quest_complete":  "Quest complete: %s (+%d points)",
+	"summary_win":     "=== You win! ===",
+	"summary_lose":    "=== Game over ===",
+	"summary_quit":    "=== Thanks for playing ===",
+	"stat_score":      "Score: %d",
+	"stat_moves":      "Moves: %d",
+	"stat_rooms":      "Rooms discovered: %d/%d",
+	"stat_items":      "Items collected: %d/%d",
+	"stat_defeated":   "Foes defeated: %d",
+	"stat_quests":     "Quests completed: %d/%d",
+	"cmd_talk":        "talk",
+	"cmd_take":        "take",
+	"cmd_attack":      "attack",
+	"cmd_score":       "score",
+	"cmd_quit":        "quit",
+	"north":           "north",
+	"south":           "south",
+	"east":            "east",
+	"west":            "west",
+	"up":              "up",
+	"down":            "down",
+	"error_rooms":     "Error loading rooms:",
+	"error_npcs":      "Error loading NPCs:",
+	"error_items":     "Error loading items:",
+	"error_game":      "Error loading game settings:",
+	"error_world":     "The adventure can't start because the world files have problems:",
+	"error_messages":  "Error loading messages:",
+	"error_gui":       "Error running game window:",
+	"panel_room":      "Room",
+	"panel_exits":     "Exits",
+	"panel_npcs":      "People",
+	"panel_items":     "Items",
+	"panel_log":       "Log",
+}
+
+// Active language and its message catalog
+var (
+	lang     = "en"
+	messages = defaultMessages
+)
+
+// Load the message catalog for a locale, falling back to English for any missing keys
+func loadMessages(locale string) error {
+	lang = locale
+	messages = make(map[string]string, len(defaultMessages))
+	for key, value := range defaultMessages {
+		messages[key] = value
+	}
+	if locale == "" || locale == "en" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(localesDir, locale+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no message catalog for language %q", locale)
+		}
+		return err
+	}
+	var catalog map[string]string
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return err
+	}
+	for key, value := range catalog {
+		messages[key] = value
+	}
+	return nil
+}
+
+// Look up a message and format it with the given arguments
+func msg(key string, args ...interface{}) string {
+	format, ok := messages[key]
+	if !ok {
+		format = key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Translate a direction for display; unknown directions are shown as-is
+func localDirection(direction string) string {
+	if _, ok := defaultMessages[direction]; !ok {
+		return direction
+	}
+	return msg(direction)
+}
+
+// Map a direction typed in the active language back to the exit key used in rooms.json
+func exitDirection(input string, exits map[string]int) string {
+	for direction := range exits {
+		if strings.EqualFold(input, localDirection(direction)) {
+			return direction
+		}
+	}
+	return input
+}
+
+// Pick the translated field for the active language, or the default text
+func localize(translations map[string]Translation, field func(Translation) string, fallback string) string {
+	if t, ok := translations[lang]; ok {
+		if value := field(t); value != "" {
+			return value
+		}
+	}
+	return fallback
+}
+
+func (r Room) LocalName() string {
+	return localize(r.Translations, func(t Translation) string { return t.Name }, r.Name)
+}
+
+func (r Room) LocalDescription() string {
+	return localize(r.Translations, func(t Translation) string { return t.Description }, r.Description)
+}
+
+func (n NPC) LocalName() string {
+	return localize(n.Translations, func(t Translation) string { return t.Name }, n.Name)
+}
+
+func (n NPC) LocalDescription() string {
+	return localize(n.Translations, func(t Translation) string { return t.Description }, n.Description)
+}
+
+func (n NPC) LocalDialogue() string {
+	return localize(n.Translations, func(t Translation) string { return t.Dialogue }, n.Dialogue)
+}
+
+func (i Item) LocalName() string {
+	return localize(i.Translations, func(t Translation) string { return t.Name }, i.Name)
+}
+
+func (i Item) LocalDescription() string {
+	return localize(i.Translations, func(t Translation) string { return t.Description }, i.Description)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Switch to a directory whose locales folder holds the given catalogs, and
+// back to English and the package directory afterwards
+func withLocales(t *testing.T, catalogs map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, localesDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for locale, data := range catalogs {
+		if err := os.WriteFile(filepath.Join(dir, localesDir, locale+".json"), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		loadMessages("en")
+	})
+}
+
+func TestLoadMessagesFallsBackToEnglish(t *testing.T) {
+	withLocales(t, map[string]string{"de": `{"you_are_in": "Du bist in %s.", "cmd_quit": "ende"}`})
+	if err := loadMessages("de"); err != nil {
+		t.Fatal(err)
+	}
+	if got := msg("you_are_in", "Halle"); got != "Du bist in Halle." {
+		t.Errorf("translated message: got %q", got)
+	}
+	if got := msg("exit", "north", 2); got != "- north to 2" {
+		t.Errorf("message missing from the catalog: got %q, want the English one", got)
+	}
+	if got := msg("no_such_key"); got != "no_such_key" {
+		t.Errorf("unknown key: got %q, want the key itself", got)
+	}
+	if lang != "de" {
+		t.Errorf("lang is %q, want de", lang)
+	}
+}
+
+func TestLoadMessagesMissingLocale(t *testing.T) {
+	withLocales(t, map[string]string{"bad": `{"exits": `})
+	err := loadMessages("fr")
+	if err == nil || !strings.Contains(err.Error(), `"fr"`) {
+		t.Errorf("missing catalog: got %v, want an error naming the language", err)
+	}
+	if got := msg("exits"); got != "Exits:" {
+		t.Errorf("after a missing catalog: got %q, want English", got)
+	}
+	if err := loadMessages("bad"); err == nil {
+		t.Error("a broken catalog loaded without an error")
+	}
+}
+
+func TestShippedCatalogIsComplete(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(localesDir, "es.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var catalog map[string]string
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		t.Fatal(err)
+	}
+	for key := range defaultMessages {
+		if catalog[key] == "" {
+			t.Errorf("es.json has no %q", key)
+		}
+	}
+	for key := range catalog {
+		if _, ok := defaultMessages[key]; !ok {
+			t.Errorf("es.json has %q, which isn't a message", key)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	t.Cleanup(func() { lang = "en" })
+	room := Room{
+		Name:         "Hall",
+		Description:  "A long hall.",
+		Translations: map[string]Translation{"es": {Name: "Salón"}},
+	}
+	npc := NPC{
+		Name:         "Guard",
+		Dialogue:     "Halt!",
+		Translations: map[string]Translation{"es": {Name: "Guardia", Dialogue: "¡Alto!"}},
+	}
+	item := Item{Name: "Key", Description: "A brass key.", Translations: map[string]Translation{"fr": {Name: "Clé"}}}
+
+	tests := []struct {
+		lang string
+		got  func() string
+		want string
+	}{
+		{"es", room.LocalName, "Salón"},
+		{"es", room.LocalDescription, "A long hall."}, // not translated, so the default
+		{"es", npc.LocalName, "Guardia"},
+		{"es", npc.LocalDialogue, "¡Alto!"},
+		{"es", item.LocalName, "Key"}, // no Spanish at all
+		{"fr", item.LocalName, "Clé"},
+		{"en", room.LocalName, "Hall"},
+		{"en", npc.LocalDialogue, "Halt!"},
+	}
+	for _, test := range tests {
+		lang = test.lang
+		if got := test.got(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.lang, got, test.want)
+		}
+	}
+}
+
+func TestDescribeUsesCatalog(t *testing.T) {
+	withLocales(t, map[string]string{"es": `{"list_entry": "* %s (%s)"}`})
+	if err := loadMessages("es"); err != nil {
+		t.Fatal(err)
+	}
+	rooms := []Room{{ID: 1, Name: "Hall", Items: []int{10}}}
+	items := []Item{{ID: 10, Name: "Stick", Description: "Sturdy.", Translations: map[string]Translation{"es": {Name: "Palo"}}}}
+	lines := newAdventure(rooms, nil, items, GameConfig{}).describe()
+	if got := lines[len(lines)-1]; got != "* Palo (Sturdy.)" {
+		t.Errorf("item line %q, want the catalog's list format", got)
+	}
+}
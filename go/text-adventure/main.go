@@ -1,149 +1,136 @@
 package main
 
 import (
-	"encoding/json"
+	"bufio"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
 )
 
 type Room struct {
-	ID          int            `json:"id"`
-	Name        string         `json:"name"`
-	Description string         `json:"description"`
-	Exits       map[string]int `json:"exits"`
-	NPCs        []int          `json:"npcs"`
-	Items       []int          `json:"items"`
+	ID          int            `json:"id" yaml:"id" toml:"id"`
+	Name        string         `json:"name" yaml:"name" toml:"name"`
+	Description string         `json:"description" yaml:"description" toml:"description"`
+	Exits       map[string]int `json:"exits" yaml:"exits" toml:"exits"`
+	NPCs        []int          `json:"npcs" yaml:"npcs" toml:"npcs"`
+	Items       []int          `json:"items" yaml:"items" toml:"items"`
+
+	Translations map[string]Translation `json:"translations,omitempty" yaml:"translations,omitempty" toml:"translations,omitempty"`
 }
 
 type NPC struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Dialogue    string `json:"dialogue"`
+	ID          int    `json:"id" yaml:"id" toml:"id"`
+	Name        string `json:"name" yaml:"name" toml:"name"`
+	Description string `json:"description" yaml:"description" toml:"description"`
+	Dialogue    string `json:"dialogue" yaml:"dialogue" toml:"dialogue"`
+	Hostile     bool   `json:"hostile" yaml:"hostile" toml:"hostile"`    // can be attacked and defeated
+	Weakness    int    `json:"weakness" yaml:"weakness" toml:"weakness"` // item needed to defeat this NPC, 0 for none
+
+	Translations map[string]Translation `json:"translations,omitempty" yaml:"translations,omitempty" toml:"translations,omitempty"`
 }
 
 type Item struct {
-	ID          int    `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	ID          int    `json:"id" yaml:"id" toml:"id"`
+	Name        string `json:"name" yaml:"name" toml:"name"`
+	Description string `json:"description" yaml:"description" toml:"description"`
+
+	Translations map[string]Translation `json:"translations,omitempty" yaml:"translations,omitempty" toml:"translations,omitempty"`
 }
 
 func loadRooms(filename string) ([]Room, error) {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-	var rooms []Room
-	err = json.Unmarshal(data, &rooms)
-	return rooms, err
+	return loadList[Room](filename, "rooms")
 }
 
 func loadNPCs(filename string) ([]NPC, error) {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-	var npcs []NPC
-	err = json.Unmarshal(data, &npcs)
-	return npcs, err
+	return loadList[NPC](filename, "npcs")
 }
 
 func loadItems(filename string) ([]Item, error) {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
-	var items []Item
-	err = json.Unmarshal(data, &items)
-	return items, err
+	return loadList[Item](filename, "items")
 }
 
 func main() {
-	rooms, err := loadRooms("rooms.json")
+	language := flag.String("lang", "en", "language for game text, e.g. en or es")
+	gui := flag.Bool("gui", false, "play in a window instead of the terminal")
+	flag.Parse()
+
+	if err := loadMessages(*language); err != nil {
+		fmt.Println(msg("error_messages"), err)
+		os.Exit(1)
+	}
+
+	files := worldFiles{
+		rooms: findWorldFile("rooms"),
+		npcs:  findWorldFile("npcs"),
+		items: findWorldFile("items"),
+		game:  findWorldFile("game"),
+	}
+
+	rooms, err := loadRooms(files.rooms)
 	if err != nil {
-		fmt.Println("Error loading rooms:", err)
+		fmt.Println(msg("error_rooms"), err)
 		os.Exit(1)
 	}
 
-	npcs, err := loadNPCs("npcs.json")
+	npcs, err := loadNPCs(files.npcs)
 	if err != nil {
-		fmt.Println("Error loading NPCs:", err)
+		fmt.Println(msg("error_npcs"), err)
 		os.Exit(1)
 	}
 
-	items, err := loadItems("items.json")
+	items, err := loadItems(files.items)
 	if err != nil {
-		fmt.Println("Error loading items:", err)
+		fmt.Println(msg("error_items"), err)
 		os.Exit(1)
 	}
 
-	inventory := make(map[int]Item) // Simple inventory system
+	config, err := loadGameConfig(files.game)
+	if err != nil {
+		fmt.Println(msg("error_game"), err)
+		os.Exit(1)
+	}
 
-	// Game loop
-	currentRoom := rooms[0] // Start in the first room
-	for {
-		fmt.Printf("\nYou are in %s.\n%s\n", currentRoom.Name, currentRoom.Description)
-		fmt.Println("Exits:")
-		for direction, roomID := range currentRoom.Exits {
-			fmt.Printf("- %s to %d\n", direction, roomID)
-		}
+	// Catch broken references before they turn into a panic mid-game
+	if err := validateWorld(files, rooms, npcs, items, config); err != nil {
+		fmt.Println(msg("error_world"))
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-		// Display NPCs
-		fmt.Println("You see:")
-		for _, npcID := range currentRoom.NPCs {
-			for _, npc := range npcs {
-				if npc.ID == npcID {
-					fmt.Printf("- %s: %s\n", npc.Name, npc.Description)
-				}
-			}
+	adventure := newAdventure(rooms, npcs, items, config)
+	if *gui {
+		if err := runGUI(adventure); err != nil {
+			fmt.Println(msg("error_gui"), err)
+			os.Exit(1)
 		}
+		return
+	}
 
-		// Display items
-		fmt.Println("Items available:")
-		for _, itemID := range currentRoom.Items {
-			for _, item := range items {
-				if item.ID == itemID {
-					fmt.Printf("- %s: %s\n", item.Name, item.Description)
-				}
-			}
+	// Game loop
+	scanner := bufio.NewScanner(os.Stdin)
+	for !adventure.over {
+		fmt.Println()
+		printLines(adventure.describe())
+
+		// Player input; end of input counts as quitting
+		fmt.Print(msg("prompt"))
+		if !scanner.Scan() {
+			fmt.Println()
+			printLines(adventure.quit())
+			return
 		}
+		printLines(adventure.handle(scanner.Text()))
+	}
+}
 
-		// Player input
-		var input string
-		fmt.Print("What do you want to do? ")
-		fmt.Scanln(&input)
-
-		// Handle movement
-		if exitRoomID, exists := currentRoom.Exits[input]; exists {
-			for _, room := range rooms {
-				if room.ID == exitRoomID {
-					currentRoom = room
-					break
-				}
-			}
-		} else if input == "talk" {
-			for _, npcID := range currentRoom.NPCs {
-				for _, npc := range npcs {
-					if npc.ID == npcID {
-						fmt.Println(npc.Dialogue)
-					}
-				}
-			}
-		} else if input == "take lantern" {
-			for _, itemID := range currentRoom.Items {
-				for _, item := range items {
-					if item.ID == itemID && item.Name == "Lantern" {
-						inventory[item.ID] = item
-						fmt.Println("You have taken the lantern.")
-						currentRoom.Items = removeItem(currentRoom.Items, item.ID) // Remove item from room
-					}
-				}
-			}
-		} else {
-			fmt.Println("You can't go that way or perform that action.")
+// Find a room by ID, or nil if there is none
+func findRoom(rooms []Room, id int) *Room {
+	for i := range rooms {
+		if rooms[i].ID == id {
+			return &rooms[i]
 		}
 	}
+	return nil
 }
 
 // Utility function to remove an item from a slice
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Names of the files the world was loaded from, for error messages
+type worldFiles struct {
+	rooms, npcs, items, game string
+}
+
+// Check that the world is playable and that every id it refers to exists.
+// All problems are reported together so authors can fix them in one pass.
+func validateWorld(files worldFiles, rooms []Room, npcs []NPC, items []Item, config GameConfig) error {
+	var problems []error
+	report := func(file string, index, id int, field, format string, args ...interface{}) {
+		worldErr := &WorldError{File: file, Index: index, Field: field, Message: fmt.Sprintf(format, args...)}
+		if index > 0 {
+			worldErr.ID = strconv.Itoa(id)
+		}
+		problems = append(problems, worldErr)
+	}
+
+	if len(rooms) == 0 {
+		report(files.rooms, 0, 0, "", "no rooms defined, the adventure needs at least one")
+	}
+
+	roomIDs := make(map[int]int) // id -> 1-based index of the first entry using it
+	for i, room := range rooms {
+		if first, taken := roomIDs[room.ID]; taken {
+			report(files.rooms, i+1, room.ID, "id", "duplicate id, already used by entry %d", first)
+		} else {
+			roomIDs[room.ID] = i + 1
+		}
+	}
+	npcIDs := make(map[int]int)
+	for i, npc := range npcs {
+		if first, taken := npcIDs[npc.ID]; taken {
+			report(files.npcs, i+1, npc.ID, "id", "duplicate id, already used by entry %d", first)
+		} else {
+			npcIDs[npc.ID] = i + 1
+		}
+	}
+	itemIDs := make(map[int]int)
+	for i, item := range items {
+		if first, taken := itemIDs[item.ID]; taken {
+			report(files.items, i+1, item.ID, "id", "duplicate id, already used by entry %d", first)
+		} else {
+			itemIDs[item.ID] = i + 1
+		}
+	}
+
+	for i, room := range rooms {
+		if room.Name == "" {
+			report(files.rooms, i+1, room.ID, "name", "missing name")
+		}
+		directions := make([]string, 0, len(room.Exits))
+		for direction := range room.Exits {
+			directions = append(directions, direction)
+		}
+		sort.Strings(directions)
+		for _, direction := range directions {
+			if target := room.Exits[direction]; roomIDs[target] == 0 {
+				report(files.rooms, i+1, room.ID, "exits."+direction, "no room with id %d", target)
+			}
+		}
+		for _, npcID := range room.NPCs {
+			if npcIDs[npcID] == 0 {
+				report(files.rooms, i+1, room.ID, "npcs", "no NPC with id %d in %s", npcID, files.npcs)
+			}
+		}
+		for _, itemID := range room.Items {
+			if itemIDs[itemID] == 0 {
+				report(files.rooms, i+1, room.ID, "items", "no item with id %d in %s", itemID, files.items)
+			}
+		}
+	}
+
+	for i, npc := range npcs {
+		if npc.Name == "" {
+			report(files.npcs, i+1, npc.ID, "name", "missing name")
+		}
+		if npc.Weakness != 0 && itemIDs[npc.Weakness] == 0 {
+			report(files.npcs, i+1, npc.ID, "weakness", "no item with id %d in %s", npc.Weakness, files.items)
+		}
+	}
+	for i, item := range items {
+		if item.Name == "" {
+			report(files.items, i+1, item.ID, "name", "missing name")
+		}
+	}
+
+	// The game file's ids all point into the other files
+	if config.StartRoom != 0 && roomIDs[config.StartRoom] == 0 {
+		report(files.game, 0, 0, "start_room", "start room %d does not exist in %s", config.StartRoom, files.rooms)
+	}
+
+	checkCondition := func(field string, c Condition) {
+		if c.empty() {
+			report(files.game, 0, 0, field, "empty condition, it would be met on the first turn")
+		}
+		if c.Room != 0 && roomIDs[c.Room] == 0 {
+			report(files.game, 0, 0, field+".room", "no room with id %d in %s", c.Room, files.rooms)
+		}
+		for _, itemID := range c.Items {
+			if itemIDs[itemID] == 0 {
+				report(files.game, 0, 0, field+".items", "no item with id %d in %s", itemID, files.items)
+			}
+		}
+		for _, itemID := range c.Lacking {
+			if itemIDs[itemID] == 0 {
+				report(files.game, 0, 0, field+".lacking", "no item with id %d in %s", itemID, files.items)
+			}
+		}
+		for _, npcID := range c.Defeated {
+			if npcIDs[npcID] == 0 {
+				report(files.game, 0, 0, field+".defeated", "no NPC with id %d in %s", npcID, files.npcs)
+			}
+		}
+	}
+	for i, quest := range config.Quests {
+		checkCondition(fmt.Sprintf("quests[%d].when", i), quest.When)
+	}
+	for i, ending := range config.Endings {
+		if ending.Outcome != "win" && ending.Outcome != "lose" {
+			report(files.game, 0, 0, fmt.Sprintf("endings[%d].outcome", i), "must be \"win\" or \"lose\", not %q", ending.Outcome)
+		}
+		checkCondition(fmt.Sprintf("endings[%d].when", i), ending.When)
+	}
+
+	return errors.Join(problems...)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testFiles = worldFiles{rooms: "rooms.json", npcs: "npcs.json", items: "items.json", game: "game.json"}
+
+func TestValidateEmptyWhen(t *testing.T) {
+	rooms := []Room{{ID: 1, Name: "Hall"}}
+	config := GameConfig{
+		Quests:  []Quest{{ID: 1, Name: "Nothing", Points: 5}},
+		Endings: []Ending{{Outcome: "win", Description: "Too soon"}},
+	}
+	err := validateWorld(testFiles, rooms, nil, nil, config)
+	if err == nil {
+		t.Fatal("empty when conditions were accepted")
+	}
+	for _, field := range []string{`"quests[0].when"`, `"endings[0].when"`} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error %q doesn't mention %s", err, field)
+		}
+	}
+}
+
+// A small world that validates; each test case breaks one thing
+func testWorld() ([]Room, []NPC, []Item, GameConfig) {
+	rooms := []Room{
+		{ID: 1, Name: "Hall", Exits: map[string]int{"north": 2}, Items: []int{10}},
+		{ID: 2, Name: "Cellar", Exits: map[string]int{"south": 1}, NPCs: []int{20}},
+	}
+	npcs := []NPC{{ID: 20, Name: "Rat", Hostile: true, Weakness: 10}}
+	items := []Item{{ID: 10, Name: "Stick"}}
+	config := GameConfig{
+		StartRoom: 1,
+		Endings:   []Ending{{Outcome: "win", When: Condition{Defeated: []int{20}}}},
+	}
+	return rooms, npcs, items, config
+}
+
+func TestValidateWorld(t *testing.T) {
+	tests := []struct {
+		name  string
+		spoil func(rooms *[]Room, npcs *[]NPC, items *[]Item, config *GameConfig)
+		want  []string // one problem each, in the order reported
+	}{
+		{"valid", func(*[]Room, *[]NPC, *[]Item, *GameConfig) {}, nil},
+		{
+			"duplicate room id",
+			func(rooms *[]Room, _ *[]NPC, _ *[]Item, _ *GameConfig) {
+				*rooms = append(*rooms, Room{ID: 2, Name: "Attic", Exits: map[string]int{"down": 1}})
+			},
+			[]string{`rooms.json: entry 3 (id 2) field "id": duplicate id, already used by entry 2`},
+		},
+		{
+			"duplicate npc and item ids",
+			func(_ *[]Room, npcs *[]NPC, items *[]Item, _ *GameConfig) {
+				*npcs = append(*npcs, NPC{ID: 20, Name: "Bat"})
+				*items = append(*items, Item{ID: 10, Name: "Stone"})
+			},
+			[]string{
+				`npcs.json: entry 2 (id 20) field "id": duplicate id, already used by entry 1`,
+				`items.json: entry 2 (id 10) field "id": duplicate id, already used by entry 1`,
+			},
+		},
+		{
+			"dangling exit",
+			func(rooms *[]Room, _ *[]NPC, _ *[]Item, _ *GameConfig) {
+				(*rooms)[1].Exits["east"] = 9
+			},
+			[]string{`rooms.json: entry 2 (id 2) field "exits.east": no room with id 9`},
+		},
+		{
+			"dangling item and npc",
+			func(rooms *[]Room, _ *[]NPC, _ *[]Item, _ *GameConfig) {
+				(*rooms)[0].Items = []int{11}
+				(*rooms)[0].NPCs = []int{21}
+			},
+			[]string{
+				`rooms.json: entry 1 (id 1) field "npcs": no NPC with id 21 in npcs.json`,
+				`rooms.json: entry 1 (id 1) field "items": no item with id 11 in items.json`,
+			},
+		},
+		{
+			"dangling weakness",
+			func(_ *[]Room, npcs *[]NPC, _ *[]Item, _ *GameConfig) {
+				(*npcs)[0].Weakness = 12
+			},
+			[]string{`npcs.json: entry 1 (id 20) field "weakness": no item with id 12 in items.json`},
+		},
+		{
+			// A secret room, or one not linked up yet, is no reason not to start
+			"unlinked room",
+			func(rooms *[]Room, _ *[]NPC, _ *[]Item, _ *GameConfig) {
+				*rooms = append(*rooms, Room{ID: 3, Name: "Vault", Exits: map[string]int{"up": 1}})
+			},
+			nil,
+		},
+		{
+			"missing start room",
+			func(_ *[]Room, _ *[]NPC, _ *[]Item, config *GameConfig) {
+				config.StartRoom = 5
+			},
+			[]string{`game.json: field "start_room": start room 5 does not exist in rooms.json`},
+		},
+		{
+			"bad ending",
+			func(_ *[]Room, _ *[]NPC, _ *[]Item, config *GameConfig) {
+				config.Endings[0].Outcome = "draw"
+				config.Endings[0].When.Items = []int{13}
+			},
+			[]string{
+				`game.json: field "endings[0].outcome": must be "win" or "lose", not "draw"`,
+				`game.json: field "endings[0].when.items": no item with id 13 in items.json`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rooms, npcs, items, config := testWorld()
+			test.spoil(&rooms, &npcs, &items, &config)
+			err := validateWorld(testFiles, rooms, npcs, items, config)
+			var got []string
+			if err != nil {
+				got = strings.Split(err.Error(), "\n")
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("got problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestValidateNoRooms(t *testing.T) {
+	err := validateWorld(testFiles, nil, nil, nil, GameConfig{})
+	if err == nil || !strings.Contains(err.Error(), "no rooms defined") {
+		t.Errorf("got %v, want no rooms defined", err)
+	}
+}
+
+// The world that ships with the game must load and validate
+func TestValidateShippedWorld(t *testing.T) {
+	files := worldFiles{
+		rooms: findWorldFile("rooms"),
+		npcs:  findWorldFile("npcs"),
+		items: findWorldFile("items"),
+		game:  findWorldFile("game"),
+	}
+	rooms, err := loadRooms(files.rooms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	npcs, err := loadNPCs(files.npcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := loadItems(files.items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadGameConfig(files.game)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateWorld(files, rooms, npcs, items, config); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateUnlinkedRoom(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "rooms.json")
+	data := `[
+  {"id": 1, "name": "Hall", "exits": {"north": 2}},
+  {"id": 2, "name": "Cellar", "exits": {"south": 1}},
+  {"id": 3, "name": "Secret Vault", "exits": {"up": 1}}
+]`
+	if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rooms, err := loadRooms(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := testFiles
+	files.rooms = filename
+	if err := validateWorld(files, rooms, nil, nil, GameConfig{StartRoom: 1}); err != nil {
+		t.Errorf("a world with an unlinked room: %v", err)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// World files can be written in any of these formats; the extension picks the decoder
+var worldExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// WorldError is a problem in a world file. Everything beyond File and Message is
+// filled in when known, so the author can go straight to the bad entry.
+type WorldError struct {
+	File    string
+	Index   int    // 1-based position of the entity in its list, 0 if not inside one
+	ID      string // the entity's id as written in the file
+	Field   string // e.g. "exits.north"
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *WorldError) Error() string {
+	location := e.File
+	if e.Line > 0 {
+		location += fmt.Sprintf(":%d", e.Line)
+		if e.Column > 0 {
+			location += fmt.Sprintf(":%d", e.Column)
+		}
+	}
+
+	var context []string
+	if e.Index > 0 {
+		context = append(context, fmt.Sprintf("entry %d", e.Index))
+	}
+	if e.ID != "" {
+		context = append(context, fmt.Sprintf("(id %s)", e.ID))
+	}
+	if e.Field != "" {
+		context = append(context, fmt.Sprintf("field %q", e.Field))
+	}
+	if len(context) == 0 {
+		return fmt.Sprintf("%s: %s", location, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", location, strings.Join(context, " "), e.Message)
+}
+
+// Find the world file for a name such as "rooms", trying each supported format in turn.
+// If none exist the JSON name is returned so the read error names a sensible file.
+func findWorldFile(name string) string {
+	for _, ext := range worldExtensions {
+		if _, err := os.Stat(name + ext); err == nil {
+			return name + ext
+		}
+	}
+	return name + ".json"
+}
+
+// Load a list of rooms, NPCs or items. JSON and YAML files hold a bare list;
+// TOML has no top-level arrays, so the list lives under key, e.g. [[rooms]].
+func loadList[T any](filename, key string) ([]T, error) {
+	var list []T
+	err := decodeFile(filename, key, &list)
+	return list, err
+}
+
+// Decode a world file into v, choosing the format from the file extension.
+// With a key, v points to a slice and entries are decoded one at a time so
+// errors can say which entry is at fault.
+func decodeFile(filename, key string, v interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var worldErr *WorldError
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		worldErr = decodeJSON(data, key != "", v)
+	case ".yaml", ".yml":
+		worldErr = decodeYAML(data, key != "", v)
+	case ".toml":
+		worldErr = decodeTOML(data, key, v)
+	default:
+		worldErr = &WorldError{Message: "unsupported file format, use .json, .yaml or .toml"}
+	}
+	if worldErr != nil {
+		worldErr.File = filename
+		return worldErr
+	}
+	return nil
+}
+
+// Decode one entry and append it to the slice that list points to
+func appendEntry(list reflect.Value, decode func(entry interface{}) error) error {
+	entry := reflect.New(list.Type().Elem())
+	if err := decode(entry.Interface()); err != nil {
+		return err
+	}
+	list.Set(reflect.Append(list, entry.Elem()))
+	return nil
+}
+
+func decodeJSON(data []byte, isList bool, v interface{}) *WorldError {
+	if !isList {
+		if err := json.Unmarshal(data, v); err != nil {
+			return jsonError(data, 0, err)
+		}
+		return nil
+	}
+
+	// Walk the array keeping each entry's raw bytes and where they start in the file
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil {
+		return jsonError(data, 0, err)
+	} else if token != json.Delim('[') {
+		return &WorldError{Line: 1, Column: 1, Message: "expected a list of entries"}
+	}
+	list := reflect.ValueOf(v).Elem()
+	// More is true at the end of the file too, which is left for the check below
+	for index := 1; decoder.More() && nextToken(data, decoder) < len(data); index++ {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			worldErr := jsonError(data, 0, err)
+			worldErr.Index = index
+			return worldErr
+		}
+		start := int(decoder.InputOffset()) - len(raw)
+
+		err := appendEntry(list, func(entry interface{}) error { return json.Unmarshal(raw, entry) })
+		if err != nil {
+			worldErr := jsonError(data, start, err)
+			worldErr.Index = index
+			var header struct {
+				ID json.RawMessage `json:"id"`
+			}
+			if json.Unmarshal(raw, &header) == nil {
+				worldErr.ID = string(header.ID)
+			}
+			return worldErr
+		}
+	}
+
+	// The list must be closed, with nothing after it
+	start := nextToken(data, decoder)
+	if token, err := decoder.Token(); start == len(data) || err == nil && token != json.Delim(']') {
+		line, column := lineColumn(data, start)
+		return &WorldError{Line: line, Column: column, Message: "the list of entries is missing its closing ]"}
+	} else if err != nil {
+		return jsonError(data, 0, err)
+	}
+	start = nextToken(data, decoder)
+	if _, err := decoder.Token(); err == nil {
+		line, column := lineColumn(data, start)
+		return &WorldError{Line: line, Column: column, Message: "unexpected content after the list of entries"}
+	} else if err != io.EOF {
+		return jsonError(data, 0, err)
+	}
+	return nil
+}
+
+// Where the decoder's next token starts, past any white space
+func nextToken(data []byte, decoder *json.Decoder) int {
+	offset := int(decoder.InputOffset())
+	return offset + len(data[offset:]) - len(bytes.TrimLeft(data[offset:], " \t\r\n"))
+}
+
+// Locate a JSON error; offsets in err are relative to base within data
+func jsonError(data []byte, base int, err error) *WorldError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, column := lineColumn(data, base+int(syntaxErr.Offset)-1) // Offset is just past the bad byte
+		return &WorldError{Line: line, Column: column, Message: syntaxErr.Error()}
+	case errors.As(err, &typeErr):
+		line, column := lineColumn(data, base+int(typeErr.Offset))
+		message := fmt.Sprintf("cannot use %s as %s", typeErr.Value, typeErr.Type)
+		return &WorldError{Line: line, Column: column, Field: typeErr.Field, Message: message}
+	default:
+		return &WorldError{Message: strings.TrimPrefix(err.Error(), "json: ")}
+	}
+}
+
+func decodeYAML(data []byte, isList bool, v interface{}) *WorldError {
+	if !isList {
+		if err := yaml.Unmarshal(data, v); err != nil {
+			return yamlError(err)
+		}
+		return nil
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return yamlError(err)
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.SequenceNode {
+		return &WorldError{Line: root.Line, Column: root.Column, Message: "expected a list of entries"}
+	}
+	list := reflect.ValueOf(v).Elem()
+	for i, node := range root.Content {
+		if err := appendEntry(list, func(entry interface{}) error { return node.Decode(entry) }); err != nil {
+			worldErr := yamlError(err)
+			worldErr.Index = i + 1
+			if id := yamlValue(node, "id"); id != nil {
+				worldErr.ID = id.Value
+			}
+			worldErr.Field = yamlField(node, worldErr.Line)
+			return worldErr
+		}
+	}
+	return nil
+}
+
+func yamlError(err error) *WorldError {
+	// Type errors carry one message per bad value; report the first
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		return lineError(typeErr.Errors[0])
+	}
+	return lineError(strings.TrimPrefix(err.Error(), "yaml: "))
+}
+
+// Value node for key in a YAML mapping, or nil
+func yamlValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Dotted path of the field whose value sits on the given line of a YAML mapping
+func yamlField(node *yaml.Node, line int) string {
+	if node.Kind != yaml.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if nested := yamlField(value, line); nested != "" {
+			return key.Value + "." + nested
+		}
+		if value.Line == line || key.Line == line {
+			return key.Value
+		}
+	}
+	return ""
+}
+
+func decodeTOML(data []byte, key string, v interface{}) *WorldError {
+	if key == "" {
+		if _, err := toml.Decode(string(data), v); err != nil {
+			return tomlError(data, err)
+		}
+		return nil
+	}
+
+	// Decode the [[key]] tables one at a time so a bad value can be traced to its table;
+	// TOML's own positions for keys repeated across tables point at the last one
+	var document map[string][]toml.Primitive
+	meta, err := toml.Decode(string(data), &document)
+	if err != nil {
+		return tomlError(data, err)
+	}
+	list := reflect.ValueOf(v).Elem()
+	for i, table := range document[key] {
+		err := appendEntry(list, func(entry interface{}) error { return meta.PrimitiveDecode(table, entry) })
+		if err != nil {
+			worldErr := tomlError(data, err)
+			worldErr.Index = i + 1
+			worldErr.Field = strings.TrimPrefix(worldErr.Field, key+".")
+			worldErr.Line, worldErr.Column = keyLine(data, key, i, worldErr.Field)
+			var header struct {
+				ID interface{} `toml:"id"`
+			}
+			if meta.PrimitiveDecode(table, &header) == nil && header.ID != nil {
+				worldErr.ID = fmt.Sprint(header.ID)
+			}
+			return worldErr
+		}
+	}
+	return nil
+}
+
+func tomlError(data []byte, err error) *WorldError {
+	var parseErr toml.ParseError
+	if errors.As(err, &parseErr) {
+		worldErr := lineError(strings.TrimPrefix(parseErr.Error(), "toml: "))
+		if parseErr.Position.Len > 0 {
+			worldErr.Line, worldErr.Column = lineColumn(data, parseErr.Position.Start)
+		}
+		return worldErr
+	}
+	return lineError(strings.TrimPrefix(err.Error(), "toml: "))
+}
+
+// Line of the index'th [[key]] table header in a TOML document
+func tableLine(data []byte, key string, index int) int {
+	header := "[[" + key + "]]"
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), header) {
+			if index == 0 {
+				return i + 1
+			}
+			index--
+		}
+	}
+	return 0
+}
+
+// Line and column of field's key within the index'th [[key]] table, falling
+// back to the table's header when the key can't be found
+func keyLine(data []byte, key string, index int, field string) (int, int) {
+	start := tableLine(data, key, index)
+	if start == 0 {
+		return 0, 0
+	}
+	lines := strings.Split(string(data), "\n")
+	table := "" // dotted path of a [key.sub] table within the entry
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "[[") {
+			break // the next entry
+		}
+		if strings.HasPrefix(line, "[") {
+			name := strings.TrimSpace(strings.Trim(line, "[]"))
+			if !strings.HasPrefix(name, key+".") {
+				break
+			}
+			table = strings.TrimPrefix(name, key+".") + "."
+			continue
+		}
+		name, _, found := strings.Cut(line, "=")
+		if !found || strings.HasPrefix(line, "#") {
+			continue
+		}
+		path := table + strings.Trim(strings.TrimSpace(name), `"'`)
+		if field == path || strings.HasPrefix(field, path+".") {
+			return i + 1, strings.Index(lines[i], line) + 1
+		}
+	}
+	return start, 0
+}
+
+// Matches the `line 12: ` or `line 12 (last key "rooms.id"): ` prefix YAML and TOML put on their messages
+var linePrefix = regexp.MustCompile(`^(?:line (\d+) ?)?(?:\(last key "([^"]*)"\))?: `)
+
+// Turn a decoder message that starts with its own location into a WorldError
+func lineError(message string) *WorldError {
+	worldErr := &WorldError{Message: message}
+	if match := linePrefix.FindStringSubmatch(message); match != nil {
+		worldErr.Line, _ = strconv.Atoi(match[1])
+		worldErr.Field = match[2]
+		worldErr.Message = message[len(match[0]):]
+	}
+	return worldErr
+}
+
+// Convert a byte offset into a 1-based line and column
+func lineColumn(data []byte, offset int) (int, int) {
+	offset = max(0, min(offset, len(data)))
+	line, column := 1, 1
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Each fixture's second room is broken; the first is fine
+const (
+	roomsJSON = `[
+  {"id": 1, "name": "Hall", "exits": {"north": 2}},
+  {"id": 2, "name": "Cellar",
+   "exits": {"south": "one"}}
+]`
+	roomsJSONSyntax = `[
+  {"id": 1, "name": "Hall"},
+  {"id": 2 "name": "Cellar"}
+]`
+	roomsJSONUnclosed = `[
+  {"id": 1, "name": "Hall"},
+  {"id": 2, "name": "Cellar"}
+`
+	roomsJSONTrailing = `[
+  {"id": 1, "name": "Hall"},
+  {"id": 2, "name": "Cellar"}
+]
+  {"id": 3, "name": "Attic"}
+`
+	roomsJSONCut     = `[{"id": 1, "name": "Hall"}`
+	roomsJSONGarbage = `[{"id": 1, "name": "Hall"}] ]`
+	roomsYAML        = `- id: 1
+  name: Hall
+  exits:
+    north: 2
+- id: 2
+  name: Cellar
+  exits:
+    south: one
+`
+	roomsYAMLSyntax = `- id: 1
+  name: Hall
+- id: 2
+  name: Cellar: Wine
+`
+	roomsTOML = `[[rooms]]
+id = 1
+name = "Hall"
+exits = { north = 2 }
+
+[[rooms]]
+id = 2
+name = "Cellar"
+exits = { south = "one" }
+`
+	roomsTOMLSubtable = `[[rooms]]
+id = 1
+name = "Hall"
+
+[[rooms]]
+id = 2
+name = "Cellar"
+
+[rooms.exits]
+south = "one"
+`
+	roomsTOMLName = `[[rooms]]
+id = 1
+name = "Hall"
+
+[[rooms]]
+id = 2
+  name = 7
+
+[[rooms]]
+id = 3
+name = "Attic"
+`
+	roomsTOMLSyntax = `[[rooms]]
+id = 1
+name = "Hall
+
+[[rooms]]
+id = 2
+`
+)
+
+func TestDecodeFileLocatesErrors(t *testing.T) {
+	tests := []struct {
+		name, file, data string
+		want             WorldError
+	}{
+		{"json type", "rooms.json", roomsJSON, WorldError{Index: 2, ID: "2", Field: "exits.south", Line: 4}},
+		{"json syntax", "rooms.json", roomsJSONSyntax, WorldError{Index: 2, Line: 3, Column: 12}},
+		{"json unclosed", "rooms.json", roomsJSONUnclosed, WorldError{Line: 4, Column: 1}},
+		{"json cut off", "rooms.json", roomsJSONCut, WorldError{Line: 1, Column: 27}},
+		{"json trailing entry", "rooms.json", roomsJSONTrailing, WorldError{Line: 5, Column: 3}},
+		{"json trailing garbage", "rooms.json", roomsJSONGarbage, WorldError{Line: 1, Column: 29}},
+		{"yaml type", "rooms.yaml", roomsYAML, WorldError{Index: 2, ID: "2", Field: "exits.south", Line: 8}},
+		{"yaml syntax", "rooms.yml", roomsYAMLSyntax, WorldError{Line: 4}},
+		{"toml inline table", "rooms.toml", roomsTOML, WorldError{Index: 2, ID: "2", Field: "exits.south", Line: 9, Column: 1}},
+		{"toml sub-table", "rooms.toml", roomsTOMLSubtable, WorldError{Index: 2, ID: "2", Field: "exits.south", Line: 10, Column: 1}},
+		{"toml key", "rooms.toml", roomsTOMLName, WorldError{Index: 2, ID: "2", Field: "name", Line: 7, Column: 3}},
+		{"toml syntax", "rooms.toml", roomsTOMLSyntax, WorldError{Field: "rooms.name", Line: 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(filename, []byte(test.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadRooms(filename)
+			var worldErr *WorldError
+			if !errors.As(err, &worldErr) {
+				t.Fatalf("got %v, want a *WorldError", err)
+			}
+			got := *worldErr
+			if got.File != filename {
+				t.Errorf("File = %q, want %q", got.File, filename)
+			}
+			if got.Index != test.want.Index || got.ID != test.want.ID || got.Field != test.want.Field {
+				t.Errorf("entry %d id %q field %q, want entry %d id %q field %q",
+					got.Index, got.ID, got.Field, test.want.Index, test.want.ID, test.want.Field)
+			}
+			if got.Line != test.want.Line {
+				t.Errorf("Line = %d, want %d (%v)", got.Line, test.want.Line, err)
+			}
+			if test.want.Column != 0 && got.Column != test.want.Column {
+				t.Errorf("Column = %d, want %d (%v)", got.Column, test.want.Column, err)
+			}
+			if got.Message == "" {
+				t.Error("no message")
+			}
+		})
+	}
+}
+
+func TestDecodeFileValid(t *testing.T) {
+	for _, file := range []string{"rooms.json", "rooms.yaml", "rooms.toml"} {
+		data := map[string]string{
+			"rooms.json": `[{"id": 1, "name": "Hall", "exits": {"north": 2}}, {"id": 2, "name": "Cellar"}]`,
+			"rooms.yaml": "- id: 1\n  name: Hall\n  exits:\n    north: 2\n- id: 2\n  name: Cellar\n",
+			"rooms.toml": "[[rooms]]\nid = 1\nname = \"Hall\"\nexits = { north = 2 }\n\n[[rooms]]\nid = 2\nname = \"Cellar\"\n",
+		}[file]
+		filename := filepath.Join(t.TempDir(), file)
+		if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		rooms, err := loadRooms(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if len(rooms) != 2 || rooms[0].Exits["north"] != 2 || rooms[1].Name != "Cellar" {
+			t.Errorf("%s decoded as %+v", file, rooms)
+		}
+	}
+}
+
+func TestDecodeJSONObject(t *testing.T) {
+	data := []byte("{\n  \"start_room\": 1,\n  \"scoring\": {\"take_item\": \"ten\"}\n}")
+	var config GameConfig
+	worldErr := decodeJSON(data, false, &config)
+	if worldErr == nil {
+		t.Fatal("bad score accepted")
+	}
+	if worldErr.Line != 3 || worldErr.Field != "scoring.take_item" || worldErr.Index != 0 {
+		t.Errorf("got line %d field %q entry %d, want line 3 field \"scoring.take_item\" entry 0",
+			worldErr.Line, worldErr.Field, worldErr.Index)
+	}
+}
+
+func TestLineError(t *testing.T) {
+	tests := []struct {
+		message, field, rest string
+		line                 int
+	}{
+		{"line 12: mapping values are not allowed", "", "mapping values are not allowed", 12},
+		{`line 4 (last key "rooms.id"): incompatible types`, "rooms.id", "incompatible types", 4},
+		{`(last key "name"): bad value`, "name", "bad value", 0},
+		{"did not find expected key", "", "did not find expected key", 0},
+	}
+	for _, test := range tests {
+		got := lineError(test.message)
+		if got.Line != test.line || got.Field != test.field || got.Message != test.rest {
+			t.Errorf("lineError(%q) = line %d field %q message %q, want line %d field %q message %q",
+				test.message, got.Line, got.Field, got.Message, test.line, test.field, test.rest)
+		}
+	}
+}
+
+func TestTableLine(t *testing.T) {
+	data := []byte(roomsTOMLName)
+	for index, want := range []int{1, 5, 9, 0} {
+		if got := tableLine(data, "rooms", index); got != want {
+			t.Errorf("tableLine(rooms, %d) = %d, want %d", index, got, want)
+		}
+	}
+	if got := tableLine(data, "items", 0); got != 0 {
+		t.Errorf("tableLine(items, 0) = %d, want 0", got)
+	}
+}
+
+func TestKeyLine(t *testing.T) {
+	data := []byte(roomsTOMLSubtable)
+	tests := []struct {
+		index        int
+		field        string
+		line, column int
+	}{
+		{0, "name", 3, 1},
+		{1, "id", 6, 1},
+		{1, "exits.south", 10, 1},
+		{1, "missing", 5, 0},
+		{2, "id", 0, 0},
+	}
+	for _, test := range tests {
+		line, column := keyLine(data, "rooms", test.index, test.field)
+		if line != test.line || column != test.column {
+			t.Errorf("keyLine(%d, %q) = %d:%d, want %d:%d", test.index, test.field, line, column, test.line, test.column)
+		}
+	}
+}